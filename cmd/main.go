@@ -44,9 +44,11 @@ func main() {
 
 	// Command-line flags
 	var configPath string
+	var dbPath string
 	var port int
 
 	flag.StringVar(&configPath, "config", "config.yaml", "Path to config file (YAML/JSON)")
+	flag.StringVar(&dbPath, "db", "routetest.db", "Path to the job history database (:memory: to disable persistence)")
 	flag.IntVar(&port, "port", 8080, "TCP port to listen on")
 	flag.Parse()
 
@@ -57,16 +59,15 @@ func main() {
 	}
 
 	// Load SSH credentials from .env and merge them with the file config
-	envCfg, err := internal.LoadSSHCredentials()
+	creds, err := internal.LoadSSHCredentials(fileCfg.Hosts)
 	if err != nil {
 		log.Fatalf("Error loading SSH credentials from .env: %v", err)
 	}
 
 	// Assemble final config struct for application
 	appConfig := &internal.AppConfig{
-		SchedulerSSH: envCfg.SchedulerSSH,
-		SdvnSSH:      envCfg.SdvnSSH,
-		File:         fileCfg,
+		Credentials: creds,
+		File:        fileCfg,
 	}
 
 	// Print application version to console
@@ -76,8 +77,15 @@ func main() {
 	// Pass version to App/handlers via package var (needed for /api/version endpoint)
 	internal.AppVersion = Version
 
+	// Open the job history store
+	store, err := internal.OpenStore(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open job history store: %v", err)
+	}
+	defer store.Close()
+
 	// Initialize the main App with all configs
-	app, err := internal.NewApp(appConfig)
+	app, err := internal.NewApp(appConfig, store)
 	if err != nil {
 		log.Fatalf("Failed to initialize application: %v", err)
 	}
@@ -8,8 +8,26 @@ export async function fetchJobResult() {
     return r.json();
 }
 
-export async function fetchRunJob() {
-    const r = await fetch("/api/runjob", { method: "POST" });
+export async function fetchJob(id) {
+    const r = await fetch(`/api/jobs/${id}`);
+    return r.json();
+}
+
+/**
+ * Opens the Server-Sent Events stream of a job (output, activity, step and done events)
+ * @param {string} id - Job ID
+ * @returns {EventSource}
+ */
+export function openJobStream(id) {
+    return new EventSource(`/api/jobs/${id}/stream`);
+}
+
+export async function fetchRunJob(profile) {
+    const r = await fetch("/api/runjob", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ profile }),
+    });
     return r.json();
 }
 
@@ -18,6 +36,16 @@ export async function fetchStopJob() {
     return r.json();
 }
 
+export async function fetchPipeline() {
+    const r = await fetch("/api/pipeline");
+    return r.json();
+}
+
+export async function fetchProfiles() {
+    const r = await fetch("/api/profiles");
+    return r.json();
+}
+
 export async function fetchVersion() {
     const r = await fetch("/api/version");
     return r.json();
@@ -28,11 +56,11 @@ export async function fetchSchedules() {
     return r.json();
 }
 
-export async function createSchedule(time) {
+export async function createSchedule(time, profile) {
     const res = await fetch("/api/schedules", {
         method: "POST",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ time }),
+        body: JSON.stringify({ time, profile }),
     });
 
     if (!res.ok) {
@@ -47,11 +75,11 @@ export async function createSchedule(time) {
     return res.json();
 }
 
-export async function updateSchedule(id, time) {
+export async function updateSchedule(id, time, profile) {
     const res = await fetch(`/api/schedules/${id}`, {
         method: "PUT",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ time }),
+        body: JSON.stringify({ time, profile }),
     });
 
     if (!res.ok) {
@@ -6,7 +6,7 @@ export class AppController {
         this.dom = dom;
         this.ui = ui;
         this.schedule = schedule;
-        this.polling = false;
+        this.stream = null;
         this.statusTimer = null;
     }
 
@@ -14,14 +14,31 @@ export class AppController {
         // Load version
         this.updateAppVersion();
 
+        // Load the pipeline stages shown in the step progress bar
+        await this.loadPipeline();
+
+        // Load the test profiles a run can select
+        await this.loadProfiles();
+
         // Wire up actions
         registerActions(this);
 
-        // Poll for job status
+        // Poll for job status while idle; running jobs are followed over their event stream
         this.statusTimer = setInterval(() => this.updateStatus(), 800);
         this.updateStatus();
     }
 
+    async loadPipeline() {
+        const p = await api.fetchPipeline();
+        // finally stages run after the pipeline and follow it in the progress bar
+        this.ui.setSteps([...(p?.stages || []), ...(p?.finally || [])]);
+    }
+
+    async loadProfiles() {
+        const p = await api.fetchProfiles();
+        this.ui.setProfiles(p?.profiles || [], p?.defaultProfile || "");
+    }
+
     async updateAppVersion() {
         const v = await api.fetchVersion();
         if (v?.version) this.dom.version.textContent = "v" + v.version;
@@ -50,11 +67,10 @@ export class AppController {
         this.ui.setOutputResult("", null);
         this.ui.setButtonState(true);
 
-        const resp = await api.fetchRunJob();
+        const resp = await api.fetchRunJob(this.ui.selectedProfile());
 
-        if (resp.Running == true) {
-            this.polling = true;
-            this.pollResult();
+        if (resp.Running == true && resp.ID) {
+            this.followJob(resp.ID);
             this.ui.showToast("Job Started!", "success");
         } else {
             this.ui.showToast("Job Failed to start", "error");
@@ -161,44 +177,77 @@ export class AppController {
     }
 
     /**
-     * Polls the backend for job results if the job is running.
-     * When complete, prints the results and resets the UI.
+     * Follows a running job over its event stream: activity and step events update
+     * the status area and progress bar, output lines are appended as they arrive.
+     * @param {string} id - Job ID
      */
-    async pollResult() {
-        const data = await api.fetchJobResult();
+    followJob(id) {
+        if (this.stream) return;
 
-        if (data.Running) {
-            setTimeout(() => this.pollResult(), 500);
-        } else {
-            this.dom.status.textContent = "Status: Job finished.";
+        this.ui.setOutputResult("", null);
+        this.ui.setButtonState(true);
 
-            // complete the step progress
-            this.ui.renderStepProgress(data.Step, true);
+        this.stream = api.openJobStream(id);
 
-            this.ui.setOutputResult(this.ui.formatOutput(data), data.RunType);
+        this.stream.addEventListener("activity", (e) => {
+            const ev = JSON.parse(e.data);
+            this.dom.status.textContent = `Status: Job is running...\n${
+                ev.activity || ""
+            }`;
+        });
 
-            this.ui.setButtonState(false);
-            this.polling = false;
+        this.stream.addEventListener("step", (e) => {
+            this.ui.renderStepProgress(JSON.parse(e.data).step, true);
+        });
 
-            // Show green toast if no error, red if any error in job result
-            if (data.Error && data.Error.length > 0) {
-                this.ui.markStepInError(data.Step);
-                this.ui.showToast("Job finished with ERROR!", "error");
-            } else {
-                this.ui.showToast("Job completed successfully.", "success");
-            }
+        this.stream.addEventListener("output", (e) => {
+            const ev = JSON.parse(e.data);
+            this.ui.appendOutputLine(`[${ev.host}] ${ev.line}`);
+        });
+
+        this.stream.addEventListener("done", () => this.finishJob(id));
+    }
+
+    /**
+     * Called when the job's stream reports completion.
+     * Prints the stored results and resets the UI.
+     * @param {string} id - Job ID
+     */
+    async finishJob(id) {
+        this.stream.close();
+        this.stream = null;
+
+        const data = await api.fetchJob(id);
+
+        this.dom.status.textContent = "Status: Job finished.";
+
+        // complete the step progress
+        this.ui.renderStepProgress(data.Step, true);
+
+        this.ui.setOutputResult(this.ui.formatOutput(data), data.RunType);
 
-            // refresh the schedules incase one was ran
-            this.schedule.loadSchedules();
+        this.ui.setButtonState(false);
+
+        // Show green toast if no error, red if any error in job result
+        if (data.Error && data.Error.length > 0) {
+            this.ui.markStepInError(data.Step);
+            this.ui.showToast("Job finished with ERROR!", "error");
+        } else {
+            this.ui.showToast("Job completed successfully.", "success");
         }
+
+        // refresh the schedules incase one was ran
+        this.schedule.loadSchedules();
     }
 
     /**
      * Polls the backend job status API and updates the status area,
-     * run button, and spinner. If a job is running, also starts polling results.
-     * Runs periodically and on page load.
+     * run button, and spinner. If a job is running, follows its event stream.
+     * Runs periodically while idle and on page load.
      */
     async updateStatus() {
+        if (this.stream) return;
+
         const status = await api.fetchJobStatus();
 
         this.ui.renderStepProgress(status.step, status.running);
@@ -208,18 +257,11 @@ export class AppController {
                 status.activity || ""
             }`;
 
-            this.ui.setButtonState(true);
-
-            if (!this.polling) {
-                this.polling = true;
-                this.pollResult();
-            }
+            this.followJob(status.id);
         } else {
             this.dom.status.textContent = `Status: Ready to run job.\n${
                 status.activity || ""
             }`;
-
-            this.polling = false;
         }
     }
 }
@@ -3,6 +3,7 @@ export function getDom() {
     return {
         nav: document.querySelector("nav"),
         runBtn: document.getElementById("runBtn"),
+        profileSelect: document.getElementById("profileSelect"),
         stopBtn: document.getElementById("stopBtn"),
         fetchBtn: document.getElementById("fetchResultBtn"),
         spinner: document.getElementById("spinner"),
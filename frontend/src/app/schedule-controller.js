@@ -92,6 +92,7 @@ export class ScheduleController {
                     <strong>
                         ${this.formatDate(sched.time)}
                     </strong>
+                    ${sched.profile ? `<small>${sched.profile}</small>` : ""}
                     <span id="spinner" class="spinner" hidden></span>
                     <div id="loading-beam" class="loading-beam-card" hidden></div>
                 </div>
@@ -165,7 +166,7 @@ export class ScheduleController {
         const time = this.pickerInput.value;
 
         try {
-            await api.createSchedule(time);
+            await api.createSchedule(time, this.ui.selectedProfile());
 
             this.loadSchedules();
             this.form.reset();
@@ -180,6 +181,7 @@ export class ScheduleController {
         this.editingId = schedule.id;
         this.pickerInput.value = schedule.time;
         this.flatpickr.setDate(schedule.time);
+        this.ui.selectProfile(schedule.profile);
         this.dom.saveScheduleBtn.textContent = "Update";
         this.dom.cancelEditBtn.hidden = false;
     }
@@ -200,7 +202,7 @@ export class ScheduleController {
         const time = this.pickerInput.value;
 
         try {
-            await api.updateSchedule(id, time);
+            await api.updateSchedule(id, time, this.ui.selectedProfile());
 
             this.loadSchedules();
             this.cancelEdit();
@@ -1,14 +1,44 @@
-const STEPS = [
-    { key: "Starting log tailing", label: "Start Log" },
-    { key: "Connecting to scheduler", label: "Scheduler" },
-    { key: "Shutting down SDVN log tailing", label: "Stop Log" },
-    { key: "Scheduler", label: "SDVN" },
-    { key: "Preparing to run local script", label: "Slab" },
-];
-
 export class UIController {
     constructor(dom) {
         this.dom = dom;
+        this.steps = [];
+    }
+
+    /**
+     * Sets the pipeline stages rendered by the step progress bar
+     * @param {Array<{name: string}>} stages - Stages from /api/pipeline
+     */
+    setSteps(stages) {
+        this.steps = stages.map((stage) => ({ label: stage.name }));
+    }
+
+    /**
+     * Fills the profile selector; it stays hidden when no profiles are configured
+     * @param {Array<{name: string, description: string}>} profiles - Profiles from /api/profiles
+     * @param {string} defaultProfile - Profile selected initially
+     */
+    setProfiles(profiles, defaultProfile) {
+        const select = this.dom.profileSelect;
+
+        select.innerHTML = "";
+        profiles.forEach((profile) => {
+            const option = document.createElement("option");
+            option.value = profile.name;
+            option.textContent = profile.name;
+            option.title = profile.description || "";
+            select.appendChild(option);
+        });
+
+        select.hidden = profiles.length === 0;
+        this.selectProfile(defaultProfile);
+    }
+
+    selectedProfile() {
+        return this.dom.profileSelect.value || "";
+    }
+
+    selectProfile(name) {
+        if (name) this.dom.profileSelect.value = name;
     }
 
     setSchedulerController(sched) {
@@ -78,10 +108,46 @@ export class UIController {
         const seperator = "_".repeat(100) + "\n\n";
         let outputParts = [];
 
+        if (results.Profile) {
+            outputParts.push(`Profile: ${results.Profile}\n\n`);
+        }
+
+        if (results.Stages && results.Stages.length > 0) {
+            results.Stages.forEach((stage) => {
+                outputParts.push(`${stage.Heading}:\n${stage.Output}\n\n`);
+                outputParts.push(seperator);
+            });
+            if (results.Latency && results.Latency.length > 0) {
+                outputParts.push("Latency:\n");
+                results.Latency.forEach((s) => {
+                    outputParts.push(
+                        `${s.segment}: min ${s.minMs}ms, avg ${s.avgMs}ms, max ${s.maxMs}ms (${s.samples} samples)\n`
+                    );
+                });
+            }
+            if (results.Verdict) {
+                outputParts.push(`Verdict: ${results.Verdict}\n`);
+                (results.Assertions || []).forEach((a) => {
+                    const status = a.Passed ? "PASS" : "FAIL";
+                    const detail = a.Detail ? ` - ${a.Detail}` : "";
+                    outputParts.push(`[${status}] ${a.Stage}: ${a.Name}${detail}\n`);
+                });
+            }
+            outputParts.push(
+                `${results.Error ? "\nError: " + results.Error : ""}`
+            );
+
+            return outputParts.join("");
+        }
+
         outputParts.push(`Scheduler:\n${results.SchedulerOutput}\n\n`);
         outputParts.push(seperator);
         outputParts.push(`SDVN:\n${results.SDVNOutput}\n\n`);
         outputParts.push(seperator);
+        if (results.SdvnTailOutput) {
+            outputParts.push(`SDVN Log Tail:\n${results.SdvnTailOutput}\n\n`);
+            outputParts.push(seperator);
+        }
         outputParts.push(`Slab:\n${results.SlabOutput}\n\n`);
         outputParts.push(seperator);
         outputParts.push(`${results.Error ? "\nError: " + results.Error : ""}`);
@@ -121,6 +187,14 @@ export class UIController {
         }
     }
 
+    /**
+     * Appends a single streamed output line while a job is running
+     * @param {string} line - Output line
+     */
+    appendOutputLine(line) {
+        this.dom.output.textContent += line + "\n";
+    }
+
     /**
      * Opens the sidebar slide out menu
      */
@@ -190,7 +264,7 @@ export class UIController {
         const stepProgressBar = document.createElement("div");
         stepProgressBar.classList.add("step-progress-bar");
 
-        STEPS.forEach((step, i) => {
+        this.steps.forEach((step, i) => {
             let statusClass = "";
 
             if (i < currentStep) statusClass = "completed";
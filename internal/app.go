@@ -7,60 +7,92 @@ import (
 	"log/slog"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
 )
 
 var AppVersion = "dev" // Default; will be overwritten by -ldflags at build time
 
 type JobResult struct {
+	ID              string
+	ScheduleID      string            `json:",omitempty"`
+	Profile         string            `json:",omitempty"` // name of the profile that ran
+	Params          map[string]string `json:",omitempty"` // params of the run request
+	StartTime       time.Time
+	EndTime         time.Time
 	SchedulerOutput string
 	SDVNOutput      string
+	SdvnTailOutput  string `json:",omitempty"` // output of the SDVN background tail, when captured
 	SlabOutput      string
 	Error           string
+	Stages          []StageResult     // one entry per pipeline stage that ran
+	TestWindow      *TestWindow       `json:",omitempty"` // span of the test stages, with padding
+	Artifacts       []string          `json:",omitempty"` // files of the job's artifact directory, e.g. spilled output
+	Assertions      []AssertionResult `json:",omitempty"` // verdicts of the stages' expect rules
+	Verdict         string            `json:",omitempty"` // PASS or FAIL by the assertions, apart from Error; empty without any
+	Timeline        []RouteEvent      `json:",omitempty"` // route events parsed from the stage output, by time
+	Latency         []LatencyStats    `json:",omitempty"` // route take latency per segment, from the timeline
 	Step            Step
 	Running         bool
 	RunType         RunType
+	Trigger         string // who or what started the run, e.g. "manual (10.0.0.5)" or "schedule <id>"
+}
+
+// RunRequest describes a single run of the runner tasks.
+type RunRequest struct {
+	ID      string
+	RunType RunType
+	Trigger string
+	Profile string            // profile name; empty selects the default profile
+	Params  map[string]string // template params that override the profile's
 }
 
 // App is the main application struct holding all state, config, and HTTP/router details.
 type App struct {
 	Config *AppConfig
 	Router *chi.Mux
+	Store  Store // persisted job history and schedules
 
 	running     bool
-	lastResult  JobResult
+	jobID       string     // id of the running job
+	jobOutput   *jobOutput // output limits of the running job
 	jobActivity string
 	step        Step
 	mutex       sync.Mutex
+	events      *EventHub // live job output/activity for the stream endpoint
+	hostKeys    *hostKeyChecker
+	agent       sshAgent // shared SSH agent connection for hosts using agent auth
 
 	// Job-cancellation support:
-	jobCancel        context.CancelFunc
-	activeSession    *ssh.Session
-	persistentHandle *SSHPersistentHandle // for new persistent background SSH jobs
+	jobCancel         context.CancelFunc
+	activeSession     *ssh.Session
+	persistentHandles []*SSHPersistentHandle // background commands started by the pipeline
 
 	// Schedule
-	scheduler       gocron.Scheduler           // global scheduler instance
-	scheduleJobs    map[string]gocron.Job      // schedule id → gocron.Job
-	schedules       map[string]*Schedule       // id → schedule struct
-	scheduleResults map[string]*ScheduleResult // id → result/output for completed jobs
-	scheduleMutex   sync.Mutex
+	scheduler     gocron.Scheduler      // global scheduler instance
+	scheduleJobs  map[string]gocron.Job // schedule id → gocron.Job
+	schedules     map[string]*Schedule  // id → schedule struct
+	scheduleMutex sync.Mutex
 }
 
 // Construction
-func NewApp(config *AppConfig) (*App, error) {
+func NewApp(config *AppConfig, store Store) (*App, error) {
 	sched, _ := gocron.NewScheduler()
 
 	app := &App{
-		Config:          config,
-		scheduler:       sched,
-		scheduleJobs:    make(map[string]gocron.Job),
-		schedules:       map[string]*Schedule{},
-		scheduleResults: map[string]*ScheduleResult{},
+		Config:       config,
+		Store:        store,
+		events:       NewEventHub(),
+		hostKeys:     newHostKeyChecker(config.File.SSH),
+		scheduler:    sched,
+		scheduleJobs: make(map[string]gocron.Job),
+		schedules:    map[string]*Schedule{},
 	}
 
 	app.jobActivity = "Idle"
@@ -82,6 +114,10 @@ func NewApp(config *AppConfig) (*App, error) {
 
 	app.Router = r
 
+	if err := app.RestoreSchedules(); err != nil {
+		return nil, fmt.Errorf("failed to restore schedules: %w", err)
+	}
+
 	log.Print("Starting Scheduler")
 	app.scheduler.Start()
 
@@ -89,84 +125,173 @@ func NewApp(config *AppConfig) (*App, error) {
 }
 
 // Application main tasks to be performed (Run or Scheduled)
+// The stages of the configured pipeline run in order; the default pipeline is:
 // 1 - Connect SSH to Magnum SDVN and start log tailing
 // 2 - Connect SSH to Magnum Scheduler and execute the scheduler script
 // 3 - Stop the log tailing on SDVN and close the connection
 // 4 - Connect SSH to Magnum SDVN and execute the script to analyze the route logs
 // 5 - Execute local script to collect the slab logs
-func (app *App) ExecuteRunnerTasks(ctx context.Context, runType RunType) JobResult {
-	result := JobResult{Running: false, RunType: runType}
+func (app *App) ExecuteRunnerTasks(ctx context.Context, req RunRequest) (result JobResult) {
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+
+	result = JobResult{
+		ID:        req.ID,
+		StartTime: time.Now(),
+		Running:   false,
+		RunType:   req.RunType,
+		Trigger:   req.Trigger,
+	}
+
+	app.events.Open(req.ID)
 
+	output := newJobOutput(app.Config.File, req.ID)
+
+	app.mutex.Lock()
+	app.jobID = req.ID
+	app.jobOutput = output
+	app.step = 0 // not the previous job's, when this one ends before a stage runs
+	app.mutex.Unlock()
+
+	// record the final step, artifacts, verdict and end time however the run finishes
+	defer func() {
+		app.mutex.Lock()
+		result.Step = app.step
+		app.mutex.Unlock()
+
+		result.Artifacts = output.Artifacts()
+
+		result.EndTime = time.Now()
+	}()
+
+	// the job reports its first error; later ones (continue_on_error and finally stages) are logged
 	checkErr := func(e error, descr string, output string) {
 		if ctx.Err() == context.Canceled {
-			result.Error = e.Error()
-			slog.Warn(result.Error)
+			slog.Warn(e.Error())
+			if result.Error == "" {
+				result.Error = e.Error()
+			}
 		} else {
-			result.Error = fmt.Sprintf("%s error: %s", descr, e.Error())
 			slog.Error(descr, "error", e, "output", output)
+			if result.Error == "" {
+				result.Error = fmt.Sprintf("%s error: %s", descr, e.Error())
+			}
 		}
 	}
 
-	var err error
-
-	// ------- Step 1: Tail log files on magnum
-	app.SetJobActivity("Starting log tailing", step.one)
-	sdvnTarget := SSHJobTarget{
-		Label:    "sdvn",
-		IP:       app.Config.File.Sdvn.IP,
-		User:     app.Config.SdvnSSH.User,
-		Pass:     app.Config.SdvnSSH.Pass,
-		Command:  app.Config.File.Sdvn.BackgroundCmd,
-		Commands: app.Config.File.Sdvn.Commands,
-	}
-	logTail, err := sshRunPersistentCmd(ctx, app, sdvnTarget)
+	profile, err := app.Profile(req.Profile)
 	if err != nil {
-		checkErr(err, "Background log tail", "")
+		result.Profile = req.Profile
+		checkErr(err, "Profile", "")
 		return result
 	}
-	defer logTail.Close()
-	app.SetPersistentHandle(logTail)
+	result.Profile = profile.Name
+
+	result.Params = req.Params
 
-	// ------- Step 2: Connecting to scheduler
-	app.SetJobActivity("Preparing to connect to scheduler", step.two)
-	schedTarget := SSHJobTarget{
-		Label:    "scheduler",
-		IP:       app.Config.File.Scheduler.IP,
-		User:     app.Config.SchedulerSSH.User,
-		Pass:     app.Config.SchedulerSSH.Pass,
-		Commands: app.Config.File.Scheduler.Commands,
+	fields, err := pipelineFields(app.Config.File.AllStages())
+	if err != nil {
+		checkErr(err, "Template", "")
+		return result
 	}
-	result.SchedulerOutput, err = sshRunCmd(ctx, app, schedTarget)
+
+	params, err := runParams(profile, req.Params, result.ID, result.StartTime, fields)
 	if err != nil {
-		checkErr(err, "Scheduler script", result.SchedulerOutput)
+		checkErr(err, "Template", "")
 		return result
 	}
 
-	// ------- Step 3: Shutdown the log tailing
-	app.SetJobActivity("Shutting down SDVN log tailing", step.three)
-	logTail.Close()
+	stages, err := renderPipeline(app.Config.File.Pipeline, params)
+	if err != nil {
+		checkErr(err, "Template", "")
+		return result
+	}
 
-	// ------- Step 4: Connecting to sdvn
-	app.SetJobActivity("Preparing to connect to sdvn", step.four)
-	result.SDVNOutput, err = sshRunCmd(ctx, app, sdvnTarget)
+	finally, err := renderPipeline(app.Config.File.Finally, params)
 	if err != nil {
-		checkErr(err, "SDVN script", result.SDVNOutput)
+		checkErr(err, "Template", "")
 		return result
 	}
 
-	// ------- Step 5: Run local script for Slab logs
-	app.SetJobActivity("Preparing to run local script", step.five)
-	localTarget := LocalJobTarget{
-		Label:    "slab",
-		Commands: app.Config.File.Slab.Commands,
+	// background commands are stopped (and their output kept) and temporary working directories
+	// removed however the run ends
+	run := newPipelineRun(app, &result)
+	defer run.Close()
+
+	failed := -1 // index of the first failed stage
+	stop := false
+
+	for i, stage := range stages {
+		if stop {
+			run.skipStage(i, stage)
+			continue
+		}
+
+		app.SetJobActivity(fmt.Sprintf("Stage %d/%d: %s", i+1, len(stages), stage.Name), Step(i))
+
+		if err := run.runStage(ctx, i, stage); err != nil {
+			checkErr(err, stage.Name, result.Stages[i].Output)
+			if failed < 0 {
+				failed = i
+			}
+			stop = ctx.Err() != nil || !stage.ContinueOnError
+		}
 	}
-	result.SlabOutput, err = localRunCmd(ctx, app, localTarget)
-	if err != nil {
-		checkErr(err, "Slab script", result.SlabOutput)
+
+	// cleanup runs after the background commands are stopped, and still runs after a stop;
+	// a second stop cancels the cleanup itself
+	run.stopBackgrounds(ctx)
+
+	window := run.window(time.Now())
+	result.TestWindow = &window
+
+	stopped := ctx.Err() != nil
+	if len(finally) > 0 && stopped {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+
+		app.mutex.Lock()
+		app.jobCancel = cancel
+		app.mutex.Unlock()
+	}
+
+	for j, stage := range finally {
+		i := len(stages) + j
+		app.SetJobActivity(fmt.Sprintf("Cleanup %d/%d: %s", j+1, len(finally), stage.Name), Step(i))
+
+		err := run.runStage(ctx, i, stage)
+		result.Stages[i].Finally = true
+
+		if err != nil {
+			checkErr(err, stage.Name, result.Stages[i].Output)
+			if failed < 0 {
+				failed = i
+			}
+		}
+	}
+
+	result.Verdict = verdict(result.Assertions)
+	result.Latency = routeLatency(result)
+
+	if stopped {
+		// the step goes back to the stage that was running, past the cleanup stages that ran after
+		// it; a stop after the last stage has no failed one
+		if failed >= 0 {
+			app.SetJobActivity("Stopped by user", Step(failed))
+		} else {
+			app.SetJobActivity("Stopped by user")
+		}
+		return result
+	}
+
+	if failed >= 0 {
+		app.SetJobActivity(fmt.Sprintf("Completed with errors (%s failed)%s", result.Stages[failed].Name, verdictNote(result.Assertions)), Step(failed))
 		return result
 	}
 
-	app.SetJobActivity("Completed", step.complete)
+	app.SetJobActivity("Completed"+verdictNote(result.Assertions), Step(len(stages)+len(finally)))
 
 	return result
 }
@@ -175,19 +300,25 @@ func (app *App) ExecuteRunnerTasks(ctx context.Context, runType RunType) JobResu
 func (app *App) ResetApp() {
 	app.mutex.Lock()
 	app.running = false
+	app.jobID = ""
+	app.jobOutput = nil
 	app.jobCancel = nil
 	app.activeSession = nil
-	app.persistentHandle = nil
+	app.persistentHandles = nil
 	app.jobActivity = "Idle"
 	app.mutex.Unlock()
 }
 
 // Helper functions for safe activity of App getterss
 func (app *App) GetLastResult() JobResult {
+	result, err := app.Store.LatestResult()
+	if err != nil && err != ErrNotFound {
+		slog.Error("failed to load last result", "error", err)
+	}
+
 	app.mutex.Lock()
 	defer app.mutex.Unlock()
 
-	result := app.lastResult
 	result.Running = app.running
 	result.Step = app.step
 
@@ -196,10 +327,12 @@ func (app *App) GetLastResult() JobResult {
 
 // Helper functions for safe activity of App setters
 func (app *App) SetLastResult(res JobResult) {
-	app.mutex.Lock()
-	defer app.mutex.Unlock()
+	if err := app.Store.SaveResult(res); err != nil {
+		slog.Error("failed to store job result", "id", res.ID, "error", err)
+	}
 
-	app.lastResult = res
+	// the result is stored, so stream clients can now fetch it
+	app.events.Close(res)
 }
 
 func (app *App) SetJobActivity(desc string, step ...Step) {
@@ -212,17 +345,29 @@ func (app *App) SetJobActivity(desc string, step ...Step) {
 		app.step = step[0]
 	}
 
+	jobID := app.jobID
 	app.mutex.Unlock()
+
+	app.events.Publish(JobEvent{Type: EventActivity, JobID: jobID, Activity: desc})
+	if len(step) > 0 {
+		app.events.Publish(JobEvent{Type: EventStep, JobID: jobID, Step: &step[0]})
+	}
 }
 
-func (app *App) SetPersistentHandle(h *SSHPersistentHandle) {
+func (app *App) AddPersistentHandle(h *SSHPersistentHandle) {
 	app.mutex.Lock()
-	app.persistentHandle = h
+	app.persistentHandles = append(app.persistentHandles, h)
 	app.mutex.Unlock()
 }
 
-func (app *App) ClearPersistentHandle() {
+func (app *App) RemovePersistentHandle(h *SSHPersistentHandle) {
 	app.mutex.Lock()
-	app.persistentHandle = nil
-	app.mutex.Unlock()
+	defer app.mutex.Unlock()
+
+	for i, handle := range app.persistentHandles {
+		if handle == h {
+			app.persistentHandles = append(app.persistentHandles[:i], app.persistentHandles[i+1:]...)
+			return
+		}
+	}
 }
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ArtifactInfo describes one stored artifact of a job.
+type ArtifactInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// collectArtifacts downloads the remote files matching target.Artifacts over SFTP on conn into the
+// job's artifact directory, as "<host>-<file name>", numbered when the job already has that name.
+// Problems are noted in output and logged; they never fail the stage. Nothing is collected once
+// the job was stopped.
+func (app *App) collectArtifacts(ctx context.Context, conn *sshClient, target SSHJobTarget, output *strings.Builder) {
+	if len(target.Artifacts) == 0 || ctx.Err() != nil {
+		return
+	}
+
+	app.SetJobActivity(fmt.Sprintf("Collecting artifacts from %s (%s)", target.Label, target.IP))
+
+	note := func(format string, args ...any) {
+		line := fmt.Sprintf(format, args...)
+		output.WriteString(line + "\n")
+		slog.Warn(line)
+	}
+
+	client, err := sftp.NewClient(conn.Client)
+	if err != nil {
+		note("[ARTIFACT ERROR] SFTP on %s: %v", target.Label, err)
+		return
+	}
+	defer client.Close()
+
+	jobOutput := app.currentJobOutput()
+	if err := os.MkdirAll(jobOutput.dir, 0o755); err != nil {
+		note("[ARTIFACT ERROR] %v", err)
+		return
+	}
+
+	for _, pattern := range target.Artifacts {
+		if target.WorkDir != "" && !path.IsAbs(pattern) {
+			pattern = path.Join(target.WorkDir, pattern)
+		}
+
+		matches, err := client.Glob(pattern)
+		if err != nil {
+			note("[ARTIFACT ERROR] %s on %s: %v", pattern, target.Label, err)
+			continue
+		}
+		if len(matches) == 0 {
+			note("[ARTIFACT ERROR] %s on %s: no matching files", pattern, target.Label)
+			continue
+		}
+
+		for _, remote := range matches {
+			if ctx.Err() != nil {
+				return
+			}
+
+			// files last written before the test window are left over from earlier runs; the host's
+			// clock may lag the runner's by up to artifacts.clock_skew
+			if skew := app.Config.File.Artifacts.ClockSkew; skew >= 0 {
+				if info, err := client.Stat(remote); err == nil && info.ModTime().Before(target.Window.From.Add(-skew)) {
+					output.WriteString(fmt.Sprintf("[ARTIFACT] %s:%s skipped: last modified %s, before the test window (clock skew allowance %s)\n",
+						target.Label, remote, info.ModTime().Format(time.RFC3339), skew))
+					continue
+				}
+			}
+
+			// files of the same name, e.g. from two directories or two stages, are numbered
+			name := jobOutput.freeArtifactName(artifactName(target.Label + "-" + path.Base(remote)))
+
+			size, err := downloadFile(client, remote, filepath.Join(jobOutput.dir, name))
+			if err != nil {
+				note("[ARTIFACT ERROR] %s on %s: %v", remote, target.Label, err)
+				continue
+			}
+
+			jobOutput.addArtifact(name)
+			output.WriteString(fmt.Sprintf("[ARTIFACT] %s:%s -> %s (%d bytes)\n", target.Label, remote, name, size))
+		}
+	}
+}
+
+// downloadFile copies the remote file to local and returns its size. Directories are skipped.
+func downloadFile(client *sftp.Client, remote, local string) (int64, error) {
+	src, err := client.Open(remote)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	if info, err := src.Stat(); err != nil {
+		return 0, err
+	} else if info.IsDir() {
+		return 0, fmt.Errorf("is a directory")
+	}
+
+	dst, err := os.Create(local)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	return n, err
+}
+
+// jobArtifacts returns the artifacts of a stored job that are still on disk.
+func (app *App) jobArtifacts(res JobResult) []ArtifactInfo {
+	artifacts := []ArtifactInfo{}
+
+	for _, name := range res.Artifacts {
+		info, err := os.Stat(filepath.Join(app.Config.File.Artifacts.Dir, res.ID, name))
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		artifacts = append(artifacts, ArtifactInfo{Name: name, Size: info.Size()})
+	}
+
+	return artifacts
+}
+
+// writeArtifactsZip writes the given artifacts of job id to w as a zip archive.
+func (app *App) writeArtifactsZip(w io.Writer, id string, artifacts []ArtifactInfo) error {
+	zw := zip.NewWriter(w)
+
+	for _, artifact := range artifacts {
+		f, err := os.Open(filepath.Join(app.Config.File.Artifacts.Dir, id, artifact.Name))
+		if err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{Name: artifact.Name, Method: zip.Deflate}
+		if info, err := f.Stat(); err == nil {
+			header.Modified = info.ModTime()
+		}
+
+		entry, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = io.Copy(entry, f)
+		}
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
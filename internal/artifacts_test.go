@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func newArtifactTestApp(t *testing.T) (*App, JobResult) {
+	t.Helper()
+
+	dir := t.TempDir()
+	app := &App{
+		Config: &AppConfig{File: FileConfig{Artifacts: ArtifactsConfig{Dir: dir}}},
+		Store:  NewMemoryStore(),
+	}
+
+	res := JobResult{ID: "job-1", Artifacts: []string{"sdvn-sxm_router.txt", "sdvn-sxm_client.txt", "gone.log"}}
+	if err := os.MkdirAll(filepath.Join(dir, res.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, res.ID, "sdvn-sxm_router.txt"), []byte("router\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, res.ID, "sdvn-sxm_client.txt"), []byte("client lines\n"), 0o644)
+	app.Store.SaveResult(res)
+
+	return app, res
+}
+
+func TestJobArtifacts(t *testing.T) {
+	app, res := newArtifactTestApp(t)
+
+	// files removed from disk are left out
+	got := app.jobArtifacts(res)
+	if len(got) != 2 || got[0] != (ArtifactInfo{"sdvn-sxm_router.txt", 7}) || got[1] != (ArtifactInfo{"sdvn-sxm_client.txt", 13}) {
+		t.Errorf("jobArtifacts() = %+v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := app.writeArtifactsZip(&buf, res.ID, got); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "sdvn-sxm_router.txt" {
+		t.Fatalf("zip entries = %v", zr.File)
+	}
+	f, _ := zr.File[0].Open()
+	data, _ := io.ReadAll(f)
+	if string(data) != "router\n" {
+		t.Errorf("zip entry = %q", data)
+	}
+}
+
+func TestArtifactHandler(t *testing.T) {
+	app, _ := newArtifactTestApp(t)
+
+	r := chi.NewRouter()
+	RegisterJobHandlers(r, app)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/jobs/job-1/artifacts/sdvn-sxm_router.txt", http.StatusOK},
+		{"/api/jobs/job-1/artifacts/missing.txt", http.StatusNotFound},
+		{"/api/jobs/job-1/artifacts/..%2F..%2Fetc%2Fpasswd", http.StatusBadRequest},
+		{"/api/jobs/..%2Fjob-1/artifacts/sdvn-sxm_router.txt", http.StatusBadRequest},
+		{"/api/jobs/job-1/artifacts/.hidden", http.StatusBadRequest},
+		{"/api/jobs/job-1/artifacts", http.StatusOK},
+		{"/api/jobs/nope/artifacts", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestCollectArtifactsOnBackgroundStop(t *testing.T) {
+	app, err := NewApp(&AppConfig{File: FileConfig{Artifacts: ArtifactsConfig{Dir: t.TempDir(), ClockSkew: -1}}}, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.jobOutput = newJobOutput(app.Config.File, "job-1")
+
+	// two files of the same name, as a glob over several directories finds them
+	remote := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		os.MkdirAll(filepath.Join(remote, dir), 0o755)
+		os.WriteFile(filepath.Join(remote, dir, "route.log"), []byte("route log "+dir+"\n"), 0o644)
+	}
+
+	srv := newTestSSHServer(t, nil, newEd25519Signer(t))
+	target := srv.target("sdvn")
+	target.Command = "tail -F /var/log/magnum.log"
+	target.Artifacts = []string{filepath.ToSlash(remote) + "/*/route.log"}
+
+	handle, _, err := sshRunPersistentCmd(context.Background(), app, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := JobResult{Stages: []StageResult{{Name: "Start Log", Status: StageSucceeded}}}
+	run := newPipelineRun(app, &res)
+	run.backgrounds["sdvn"] = &backgroundStage{handle: handle, stage: StageConfig{Name: "Start Log"}, target: target}
+
+	run.stopBackground(context.Background(), "sdvn", TestWindow{})
+
+	want := []string{"sdvn-route.log", "sdvn-route-2.log"}
+	if got := app.jobOutput.Artifacts(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("artifacts = %v, want %v (stage output %q)", got, want, res.Stages[0].Output)
+	}
+	contents := map[string]bool{}
+	for _, name := range want {
+		data, _ := os.ReadFile(filepath.Join(app.jobOutput.dir, name))
+		contents[string(data)] = true
+	}
+	if !contents["route log a\n"] || !contents["route log b\n"] {
+		t.Errorf("artifact contents = %v, want both files", contents)
+	}
+	if !strings.Contains(res.Stages[0].Output, "-> sdvn-route-2.log") {
+		t.Errorf("stage output = %q", res.Stages[0].Output)
+	}
+	if len(run.backgrounds) != 0 {
+		t.Error("the background command is still registered")
+	}
+}
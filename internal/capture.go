@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cappedBuffer is a goroutine-safe io.Writer that keeps at most maxBytes and maxLines of output
+// (zero means unlimited) and counts what it had to drop. It remembers when each kept line arrived.
+type cappedBuffer struct {
+	mutex        sync.Mutex
+	buf          bytes.Buffer
+	starts       []int       // offset of each kept line in buf
+	arrived      []time.Time // arrival time of each kept line
+	lines        int
+	maxBytes     int
+	maxLines     int
+	droppedBytes int
+	droppedLines int
+}
+
+func newCappedBuffer(maxBytes, maxLines int) *cappedBuffer {
+	return &cappedBuffer{maxBytes: maxBytes, maxLines: maxLines}
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	written := len(p)
+	now := time.Now()
+
+	for len(p) > 0 {
+		// one line (including its newline) at a time, so the line limit cuts on line boundaries
+		n := bytes.IndexByte(p, '\n') + 1
+		if n == 0 {
+			n = len(p)
+		}
+		chunk := p[:n]
+		p = p[n:]
+
+		full := (c.maxLines > 0 && c.lines >= c.maxLines) ||
+			(c.maxBytes > 0 && c.buf.Len()+len(chunk) > c.maxBytes)
+
+		if full {
+			c.droppedBytes += len(chunk)
+			if chunk[len(chunk)-1] == '\n' {
+				c.droppedLines++
+			}
+			continue
+		}
+
+		if b := c.buf.Bytes(); len(b) == 0 || b[len(b)-1] == '\n' {
+			c.starts = append(c.starts, len(b))
+			c.arrived = append(c.arrived, now)
+		}
+
+		c.buf.Write(chunk)
+		if chunk[len(chunk)-1] == '\n' {
+			c.lines++
+		}
+	}
+
+	return written, nil
+}
+
+// Between returns the kept lines that arrived within the window, followed by a note of how many
+// lines were left out.
+func (c *cappedBuffer) Between(window TestWindow) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var out strings.Builder
+	b := c.buf.Bytes()
+	outside := 0
+
+	for i, start := range c.starts {
+		end := len(b)
+		if i+1 < len(c.starts) {
+			end = c.starts[i+1]
+		}
+
+		if !window.Contains(c.arrived[i]) {
+			outside++
+			continue
+		}
+		out.Write(b[start:end])
+	}
+
+	if outside > 0 {
+		if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString("\n")
+		}
+		out.WriteString(fmt.Sprintf("[WINDOW] %d lines outside the test window %s left out\n", outside, window))
+	}
+	if c.droppedBytes > 0 {
+		out.WriteString(fmt.Sprintf("[TRUNCATED] %d more lines (%d bytes) not captured\n", c.droppedLines, c.droppedBytes))
+	}
+
+	return out.String()
+}
+
+// String returns the kept output, followed by a truncation marker when anything was dropped.
+func (c *cappedBuffer) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.droppedBytes == 0 {
+		return c.buf.String()
+	}
+
+	return fmt.Sprintf("%s\n[TRUNCATED] %d more lines (%d bytes) not captured\n", c.buf.String(), c.droppedLines, c.droppedBytes)
+}
+
+// jobOutput limits the command output kept by one job, spills truncated output to disk and keeps
+// the list of the job's artifacts.
+type jobOutput struct {
+	mutex      sync.Mutex
+	jobID      string
+	dir        string // the job's artifact directory
+	spill      bool   // write truncated output to the artifact directory
+	maxCommand int    // per stream of a command attempt
+	remaining  int    // left of the job's budget
+	seq        int
+	artifacts  []string
+}
+
+func newJobOutput(cfg FileConfig, jobID string) *jobOutput {
+	out := &jobOutput{
+		jobID:      jobID,
+		maxCommand: cfg.Output.MaxCommandBytes,
+		remaining:  cfg.Output.MaxJobBytes,
+		dir:        filepath.Join(cfg.Artifacts.Dir, jobID),
+		spill:      cfg.Output.SpillToDisk && jobID != "",
+	}
+
+	return out
+}
+
+// newBuffer returns the buffer for one stream of a command attempt, reserving its limit from the
+// job's budget.
+func (o *jobOutput) newBuffer(label string, command int, stream string) *outputBuffer {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	limit := min(o.maxCommand, o.remaining)
+	o.remaining -= limit
+	o.seq++
+
+	buf := &outputBuffer{owner: o, limit: limit}
+	if o.spill {
+		buf.spillName = artifactName(fmt.Sprintf("%03d-%s-cmd%d-%s.log", o.seq, label, command, stream))
+	}
+
+	return buf
+}
+
+// release returns what a finished buffer did not use to the job's budget and records its spill file.
+func (o *jobOutput) release(buf *outputBuffer, unused int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.remaining += unused
+	if buf.spill != nil {
+		o.artifacts = append(o.artifacts, buf.spillName)
+	}
+}
+
+// addArtifact records a file stored in the job's artifact directory.
+func (o *jobOutput) addArtifact(name string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !slices.Contains(o.artifacts, name) {
+		o.artifacts = append(o.artifacts, name)
+	}
+}
+
+// freeArtifactName returns name, or when the job already has an artifact of that name, name with
+// the first free "-2", "-3", ... before its extension.
+func (o *jobOutput) freeArtifactName(name string) string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	free := name
+	for n := 2; slices.Contains(o.artifacts, free); n++ {
+		free = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+
+	return free
+}
+
+// Artifacts returns the names of the job's artifacts so far.
+func (o *jobOutput) Artifacts() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return slices.Clone(o.artifacts)
+}
+
+// outputBuffer is a goroutine-safe io.Writer that keeps the head and the tail of a command's output
+// within limit bytes. When output is dropped and spilling is enabled, the full output is written to
+// an artifact file instead.
+type outputBuffer struct {
+	mutex     sync.Mutex
+	owner     *jobOutput
+	limit     int
+	head      []byte
+	tail      []byte
+	total     int
+	spillName string
+	spill     *os.File
+	finished  bool
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	written := len(p)
+	if b.finished {
+		return written, nil
+	}
+
+	if b.spill == nil && b.spillName != "" && b.total+len(p) > b.limit {
+		b.startSpill()
+	}
+	if b.spill != nil {
+		if _, err := b.spill.Write(p); err != nil {
+			slog.Warn("failed to spill command output", "file", b.spill.Name(), "error", err)
+		}
+	}
+
+	b.total += len(p)
+
+	headMax := b.limit / 2
+	if n := min(headMax-len(b.head), len(p)); n > 0 {
+		b.head = append(b.head, p[:n]...)
+		p = p[n:]
+	}
+
+	// keep the last limit-headMax bytes; trimming only once the slice doubles keeps writes cheap
+	tailMax := b.limit - headMax
+	b.tail = append(b.tail, p...)
+	if len(b.tail) > 2*tailMax {
+		b.tail = append(b.tail[:0:0], b.tail[len(b.tail)-tailMax:]...)
+	}
+
+	return written, nil
+}
+
+// startSpill opens the spill file and writes what was received so far, which is still complete.
+func (b *outputBuffer) startSpill() {
+	if err := os.MkdirAll(b.owner.dir, 0o755); err != nil {
+		slog.Warn("failed to create artifact directory", "dir", b.owner.dir, "error", err)
+		b.spillName = ""
+		return
+	}
+
+	f, err := os.Create(filepath.Join(b.owner.dir, b.spillName))
+	if err != nil {
+		slog.Warn("failed to create spill file", "error", err)
+		b.spillName = ""
+		return
+	}
+
+	f.Write(b.head)
+	f.Write(b.tail)
+	b.spill = f
+}
+
+// Finish closes the spill file and returns the unused part of the limit to the job. Later writes
+// are ignored.
+func (b *outputBuffer) Finish() {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.finished {
+		return
+	}
+	b.finished = true
+
+	if b.spill != nil {
+		b.spill.Close()
+	}
+
+	b.owner.release(b, b.limit-min(b.total, b.limit))
+}
+
+// Truncated reports whether output was dropped.
+func (b *outputBuffer) Truncated() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.total > b.limit
+}
+
+// Artifact returns the name of the spill file holding the full output, if any.
+func (b *outputBuffer) Artifact() string {
+	if b == nil {
+		return ""
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.spill == nil {
+		return ""
+	}
+	return b.spillName
+}
+
+// String returns the kept output; when output was dropped, the head and tail are separated by a
+// truncation marker.
+func (b *outputBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.total <= b.limit {
+		return string(b.head) + string(b.tail)
+	}
+
+	// the tail starts at a line boundary when it holds one
+	tail := b.tail[max(len(b.tail)-(b.limit-b.limit/2), 0):]
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	dropped := b.total - len(b.head) - len(tail)
+
+	where := ""
+	if b.spill != nil {
+		where = fmt.Sprintf(", full output in artifact %s", b.spillName)
+	}
+
+	sep := ""
+	if len(b.head) > 0 && b.head[len(b.head)-1] != '\n' {
+		sep = "\n"
+	}
+
+	return fmt.Sprintf("%s%s[TRUNCATED] %d bytes omitted%s\n%s", b.head, sep, dropped, where, tail)
+}
+
+// artifactName makes s safe to use as a file name.
+func artifactName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
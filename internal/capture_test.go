@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestJobOutput(t *testing.T, maxCommand, maxJob int, spill bool) *jobOutput {
+	t.Helper()
+
+	return newJobOutput(FileConfig{
+		Output:    OutputConfig{MaxCommandBytes: maxCommand, MaxJobBytes: maxJob, SpillToDisk: spill},
+		Artifacts: ArtifactsConfig{Dir: t.TempDir()},
+	}, "job-1")
+}
+
+func TestOutputBufferWithinLimit(t *testing.T) {
+	buf := newTestJobOutput(t, 64, 1024, false).newBuffer("sdvn", 1, "stdout")
+	buf.Write([]byte("line1\n"))
+	buf.Write([]byte("line2\n"))
+	buf.Finish()
+
+	if got := buf.String(); got != "line1\nline2\n" {
+		t.Errorf("String() = %q", got)
+	}
+	if buf.Truncated() || buf.Artifact() != "" {
+		t.Errorf("Truncated() = %v, Artifact() = %q, want neither", buf.Truncated(), buf.Artifact())
+	}
+}
+
+func TestOutputBufferHeadAndTail(t *testing.T) {
+	input := "line1\nline2\nline3\nline4\nline5\n"
+
+	for _, chunked := range []bool{false, true} {
+		buf := newTestJobOutput(t, 20, 1024, false).newBuffer("sdvn", 1, "stdout")
+		if chunked {
+			for _, line := range strings.SplitAfter(input, "\n") {
+				buf.Write([]byte(line))
+			}
+		} else {
+			buf.Write([]byte(input))
+		}
+		buf.Finish()
+
+		// 10 bytes of head, the tail cut back to its first whole line
+		want := "line1\nline\n[TRUNCATED] 14 bytes omitted\nline5\n"
+		if got := buf.String(); got != want {
+			t.Errorf("chunked=%v: String() = %q, want %q", chunked, got, want)
+		}
+		if !buf.Truncated() {
+			t.Errorf("chunked=%v: Truncated() = false", chunked)
+		}
+	}
+}
+
+func TestOutputBufferSpill(t *testing.T) {
+	out := newTestJobOutput(t, 20, 1024, true)
+	buf := out.newBuffer("sdvn host", 2, "stderr")
+
+	input := strings.Repeat("0123456789\n", 5)
+	buf.Write([]byte(input[:15]))
+	buf.Write([]byte(input[15:]))
+	buf.Finish()
+	buf.Write([]byte("after finish\n"))
+
+	name := buf.Artifact()
+	if name != "001-sdvn_host-cmd2-stderr.log" {
+		t.Fatalf("Artifact() = %q", name)
+	}
+	if !strings.Contains(buf.String(), "full output in artifact "+name) {
+		t.Errorf("String() = %q, want a pointer to the spill file", buf.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(out.dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != input {
+		t.Errorf("spill file = %q, want the full output", data)
+	}
+	if got := out.Artifacts(); len(got) != 1 || got[0] != name {
+		t.Errorf("Artifacts() = %v", got)
+	}
+}
+
+func TestJobOutputBudget(t *testing.T) {
+	out := newTestJobOutput(t, 100, 150, false)
+
+	first := out.newBuffer("a", 1, "stdout")
+	second := out.newBuffer("a", 1, "stderr")
+	if first.limit != 100 || second.limit != 50 {
+		t.Fatalf("limits = %d, %d, want 100, 50", first.limit, second.limit)
+	}
+	if third := out.newBuffer("a", 2, "stdout"); third.limit != 0 {
+		t.Fatalf("budget exhausted, limit = %d, want 0", third.limit)
+	}
+
+	// the unused part of a finished buffer goes back to the job
+	first.Write([]byte("0123456789"))
+	first.Finish()
+	first.Finish() // only released once
+	if out.remaining != 90 {
+		t.Fatalf("remaining = %d, want 90", out.remaining)
+	}
+	if next := out.newBuffer("b", 1, "stdout"); next.limit != 90 {
+		t.Errorf("limit after release = %d, want 90", next.limit)
+	}
+
+	// a buffer without any budget keeps nothing and says so
+	empty := newTestJobOutput(t, 100, 0, false).newBuffer("c", 1, "stdout")
+	empty.Write([]byte("dropped\n"))
+	if got := empty.String(); !strings.Contains(got, "[TRUNCATED] 8 bytes omitted") {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	lines := newCappedBuffer(0, 2)
+	lines.Write([]byte("a\nb\nc\nd\n"))
+	if got, want := lines.String(), "a\nb\n\n[TRUNCATED] 2 more lines (4 bytes) not captured\n"; got != want {
+		t.Errorf("line limit: String() = %q, want %q", got, want)
+	}
+
+	bytes := newCappedBuffer(5, 0)
+	bytes.Write([]byte("ab\ncd\nef\n"))
+	if got, want := bytes.String(), "ab\n\n[TRUNCATED] 2 more lines (6 bytes) not captured\n"; got != want {
+		t.Errorf("byte limit: String() = %q, want %q", got, want)
+	}
+}
+
+func TestArtifactName(t *testing.T) {
+	tests := map[string]string{
+		"sdvn-sxm_router.txt":        "sdvn-sxm_router.txt",
+		"001-sdvn host-cmd1-out.log": "001-sdvn_host-cmd1-out.log",
+		"../../etc/passwd":           ".._.._etc_passwd",
+		`C:\temp\x.log`:              "C__temp_x.log",
+		"scheduler-route:1 (ü).txt":  "scheduler-route_1____.txt",
+	}
+
+	for in, want := range tests {
+		got := artifactName(in)
+		if got != want {
+			t.Errorf("artifactName(%q) = %q, want %q", in, got, want)
+		}
+		if strings.ContainsAny(got, `/\`) {
+			t.Errorf("artifactName(%q) = %q keeps a path separator", in, got)
+		}
+	}
+}
+
+func TestFreeArtifactName(t *testing.T) {
+	out := newTestJobOutput(t, 64, 1024, false)
+	out.addArtifact("sdvn-route.log")
+	out.addArtifact("sdvn-route-2.log")
+	out.addArtifact("sdvn-README")
+
+	tests := map[string]string{
+		"sdvn-route.log":  "sdvn-route-3.log",
+		"sdvn-README":     "sdvn-README-2",
+		"sdvn-client.log": "sdvn-client.log",
+	}
+	for name, want := range tests {
+		if got := out.freeArtifactName(name); got != want {
+			t.Errorf("freeArtifactName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
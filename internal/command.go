@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CommandConfig is one command of a stage. In config it is either a plain string or an object
+// with a run policy:
+//
+//	commands:
+//	  - "echo plain"
+//	  - run: "python3 sdvn_script.py"
+//	    timeout: 5m
+//	    retries: 2
+//	    retry_delay: 10s
+//	    allow_failure: true
+//	  - run: "python3 scheduler_script.py"
+//	    take: true
+type CommandConfig struct {
+	Run          string        `mapstructure:"run"`
+	Timeout      time.Duration `mapstructure:"timeout"`       // zero means no timeout
+	Retries      int           `mapstructure:"retries"`       // extra attempts after a failure or timeout
+	RetryDelay   time.Duration `mapstructure:"retry_delay"`   // wait between attempts
+	AllowFailure bool          `mapstructure:"allow_failure"` // a command that still fails does not fail the stage
+	Take         bool          `mapstructure:"take"`          // starts a route take; the take latency is measured from its start
+}
+
+// commandDecodeHook lets a command be written as a plain string in config.
+func commandDecodeHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if to != reflect.TypeOf(CommandConfig{}) || from.Kind() != reflect.String {
+		return data, nil
+	}
+
+	return CommandConfig{Run: data.(string)}, nil
+}
+
+// commandRuns returns the command lines of cmds.
+func commandRuns(cmds []CommandConfig) []string {
+	runs := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		runs[i] = cmd.Run
+	}
+
+	return runs
+}
+
+// CommandResult is the record of one attempt of a stage command.
+type CommandResult struct {
+	Command        string
+	Attempt        int // 1 for the first run, higher for retries
+	StartTime      time.Time
+	EndTime        time.Time
+	DurationMs     int64
+	ExitStatus     int // -1 when the command did not exit on its own (canceled, timed out, connection lost)
+	Stdout         string
+	Stderr         string
+	Truncated      bool   `json:",omitempty"` // stdout or stderr exceeded the output limits
+	StdoutArtifact string `json:",omitempty"` // artifact holding the full stdout, when it was spilled
+	StderrArtifact string `json:",omitempty"`
+	Error          string `json:",omitempty"`
+	Canceled       bool   `json:",omitempty"` // stopped by the user
+	TimedOut       bool   `json:",omitempty"`
+	AllowedFailure bool   `json:",omitempty"` // failed, but allow_failure let the stage continue
+	Take           bool   `json:",omitempty"` // the command starts a route take
+}
+
+// commandLog collects what the commands of a stage produced: the legacy text output and one
+// record per attempt.
+type commandLog struct {
+	output  strings.Builder
+	results []CommandResult
+}
+
+// exitStatus returns the exit status carried by a command error: 0 for nil, the status of an
+// *ssh.ExitError or *exec.ExitError, and -1 otherwise.
+func exitStatus(err error) int {
+	var sshErr *ssh.ExitError
+	var execErr *exec.ExitError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &sshErr):
+		return sshErr.ExitStatus()
+	case errors.As(err, &execErr):
+		return execErr.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// commandAttempt runs a command once and returns its finished stdout and stderr buffers (nil when the
+// command did not start). When ctx ends first it must stop the command and return ctx's error.
+type commandAttempt func(ctx context.Context) (stdout *outputBuffer, stderr *outputBuffer, err error)
+
+// runCommandPolicy runs attempt under the timeout, retry and allow_failure policy of cmd and records
+// every attempt in log. where describes the command for activity messages, e.g. "command 1/2 on sdvn".
+// It returns context.Canceled when the job was stopped.
+func (app *App) runCommandPolicy(ctx context.Context, cmd CommandConfig, where string, log *commandLog, attempt commandAttempt) error {
+	var err error
+	output := &log.output
+
+	for n := 0; n <= cmd.Retries; n++ {
+		if n > 0 {
+			app.SetJobActivity(fmt.Sprintf("Retrying %s in %s (attempt %d/%d):\n%s", where, cmd.RetryDelay, n+1, cmd.Retries+1, cmd.Run))
+			output.WriteString(fmt.Sprintf("[RETRY %d/%d] %s\n", n, cmd.Retries, cmd.Run))
+
+			select {
+			case <-ctx.Done():
+				return context.Canceled
+			case <-time.After(cmd.RetryDelay):
+			}
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cmd.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		}
+
+		res := CommandResult{Command: cmd.Run, Attempt: n + 1, StartTime: time.Now(), Take: cmd.Take}
+
+		var stdout, stderr *outputBuffer
+		stdout, stderr, err = attempt(attemptCtx)
+		cancel()
+
+		res.Stdout, res.Stderr = stdout.String(), stderr.String()
+		res.Truncated = stdout.Truncated() || stderr.Truncated()
+		res.StdoutArtifact, res.StderrArtifact = stdout.Artifact(), stderr.Artifact()
+
+		res.EndTime = time.Now()
+		res.DurationMs = res.EndTime.Sub(res.StartTime).Milliseconds()
+		res.ExitStatus = exitStatus(err)
+		res.Canceled = ctx.Err() != nil
+		res.TimedOut = !res.Canceled && attemptCtx.Err() == context.DeadlineExceeded
+		if res.Canceled || res.TimedOut {
+			res.ExitStatus = -1
+		}
+
+		out := res.Stdout + res.Stderr
+
+		switch {
+		case res.Canceled:
+			res.Error = context.Canceled.Error()
+			log.results = append(log.results, res)
+			output.WriteString(fmt.Sprintf("[CANCELED] Command: %s\nOutput:\n%s\n", cmd.Run, out))
+			return context.Canceled
+
+		case res.TimedOut:
+			err = fmt.Errorf("command timed out after %s", cmd.Timeout)
+			app.SetJobActivity(fmt.Sprintf("Timed out %s after %s:\n%s", where, cmd.Timeout, cmd.Run))
+			output.WriteString(fmt.Sprintf("[TIMEOUT] Command: %s\nOutput:\n%s\n[ERROR] Command timed out after %s\n", cmd.Run, out, cmd.Timeout))
+
+		default:
+			output.WriteString(fmt.Sprintf("Command: %s\nOutput:\n%s\n", cmd.Run, out))
+			if err == nil {
+				log.results = append(log.results, res)
+				return nil
+			}
+			output.WriteString(fmt.Sprintf("[ERROR] Command failed: %v\n", err))
+		}
+
+		res.Error = err.Error()
+		log.results = append(log.results, res)
+	}
+
+	if cmd.AllowFailure {
+		log.results[len(log.results)-1].AllowedFailure = true
+		output.WriteString(fmt.Sprintf("[ALLOWED FAILURE] Continuing after: %v\n", err))
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCommandTestApp returns an app that runs commands outside of a job, with the given scripts
+// written to a temporary directory, which it returns.
+func newCommandTestApp(t *testing.T, scripts map[string]string) (*App, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := &App{Config: &AppConfig{}, events: NewEventHub()}
+	app.jobOutput = newTestJobOutput(t, 64<<10, 1<<20, false)
+
+	return app, dir
+}
+
+func TestCommandPolicy(t *testing.T) {
+	// flaky.sh fails until its second run
+	app, dir := newCommandTestApp(t, map[string]string{
+		"flaky.sh": "n=$(cat \"$1\" 2>/dev/null || echo 0)\nn=$((n+1))\necho $n > \"$1\"\necho attempt $n\n[ $n -ge 2 ]\n",
+	})
+	flaky := func(name string) string {
+		return fmt.Sprintf("sh %s %s", filepath.Join(dir, "flaky.sh"), filepath.Join(dir, name))
+	}
+
+	type attempt struct {
+		attempt    int
+		exitStatus int
+		timedOut   bool
+		allowed    bool
+	}
+
+	tests := []struct {
+		name       string
+		commands   []CommandConfig
+		want       []attempt
+		wantErr    string
+		wantOutput string
+	}{
+		{
+			name:     "success",
+			commands: []CommandConfig{{Run: "true"}},
+			want:     []attempt{{attempt: 1}},
+		},
+		{
+			name:     "failure stops the stage",
+			commands: []CommandConfig{{Run: "false"}, {Run: "true"}},
+			want:     []attempt{{attempt: 1, exitStatus: 1}},
+			wantErr:  "exit status 1",
+		},
+		{
+			name:       "retry until success",
+			commands:   []CommandConfig{{Run: flaky("count1"), Retries: 2, RetryDelay: 10 * time.Millisecond}},
+			want:       []attempt{{attempt: 1, exitStatus: 1}, {attempt: 2}},
+			wantOutput: "[RETRY 1/2]",
+		},
+		{
+			name:     "retries exhausted",
+			commands: []CommandConfig{{Run: "false", Retries: 2}},
+			want:     []attempt{{attempt: 1, exitStatus: 1}, {attempt: 2, exitStatus: 1}, {attempt: 3, exitStatus: 1}},
+			wantErr:  "exit status 1",
+		},
+		{
+			name:       "allow failure",
+			commands:   []CommandConfig{{Run: "false", AllowFailure: true}, {Run: "true"}},
+			want:       []attempt{{attempt: 1, exitStatus: 1, allowed: true}, {attempt: 1}},
+			wantOutput: "[ALLOWED FAILURE]",
+		},
+		{
+			name:     "allow failure after retries",
+			commands: []CommandConfig{{Run: "false", Retries: 1, AllowFailure: true}},
+			want:     []attempt{{attempt: 1, exitStatus: 1}, {attempt: 2, exitStatus: 1, allowed: true}},
+		},
+		{
+			name:       "timeout",
+			commands:   []CommandConfig{{Run: "sleep 5", Timeout: 100 * time.Millisecond}},
+			want:       []attempt{{attempt: 1, exitStatus: -1, timedOut: true}},
+			wantErr:    "command timed out after 100ms",
+			wantOutput: "[TIMEOUT]",
+		},
+		{
+			name:     "timeout then retry",
+			commands: []CommandConfig{{Run: "sleep 5", Timeout: 100 * time.Millisecond, Retries: 1}},
+			want:     []attempt{{attempt: 1, exitStatus: -1, timedOut: true}, {attempt: 2, exitStatus: -1, timedOut: true}},
+			wantErr:  "timed out",
+		},
+		{
+			name:     "timeout allowed to fail",
+			commands: []CommandConfig{{Run: "sleep 5", Timeout: 100 * time.Millisecond, AllowFailure: true}, {Run: "true"}},
+			want:     []attempt{{attempt: 1, exitStatus: -1, timedOut: true, allowed: true}, {attempt: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, results, err := localRunCmd(context.Background(), app, LocalJobTarget{Label: "local", Commands: tt.commands})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("localRunCmd() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("localRunCmd() error = %v, want %q", err, tt.wantErr)
+			}
+			if !strings.Contains(output, tt.wantOutput) {
+				t.Errorf("output = %q, want %q in it", output, tt.wantOutput)
+			}
+
+			var got []attempt
+			for _, res := range results {
+				got = append(got, attempt{attempt: res.Attempt, exitStatus: res.ExitStatus, timedOut: res.TimedOut, allowed: res.AllowedFailure})
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("attempts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandPolicyCanceled(t *testing.T) {
+	app, _ := newCommandTestApp(t, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// neither a retry nor allow_failure outlives a stopped job
+	cmd := CommandConfig{Run: "sleep 5", Retries: 3, AllowFailure: true}
+	_, results, err := localRunCmd(ctx, app, LocalJobTarget{Label: "local", Commands: []CommandConfig{cmd, {Run: "true"}}})
+	if err == nil || !strings.Contains(err.Error(), "stopped by user") {
+		t.Fatalf("localRunCmd() error = %v, want stopped by user", err)
+	}
+	if len(results) != 1 || !results[0].Canceled || results[0].ExitStatus != -1 || results[0].AllowedFailure {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestCommandResults(t *testing.T) {
+	app, dir := newCommandTestApp(t, map[string]string{
+		"take.sh":   "echo taken\necho warn >&2\n",
+		"status.sh": "echo degraded >&2\nexit 3\n",
+	})
+
+	srv := newTestSSHServer(t, nil, newEd25519Signer(t))
+	srv.exec = func(cmd string, stdout, stderr io.Writer) int {
+		switch {
+		case strings.Contains(cmd, "take.sh"):
+			fmt.Fprintln(stdout, "taken")
+			fmt.Fprintln(stderr, "warn")
+			return 0
+		default:
+			fmt.Fprintln(stderr, "degraded")
+			return 3
+		}
+	}
+
+	take := "sh " + filepath.Join(dir, "take.sh")
+	status := "sh " + filepath.Join(dir, "status.sh")
+
+	tests := []struct {
+		name string
+		run  func(cmds []CommandConfig) (string, []CommandResult, error)
+	}{
+		{
+			name: "local",
+			run: func(cmds []CommandConfig) (string, []CommandResult, error) {
+				return localRunCmd(context.Background(), app, LocalJobTarget{Label: "local", Commands: cmds})
+			},
+		},
+		{
+			name: "ssh",
+			run: func(cmds []CommandConfig) (string, []CommandResult, error) {
+				target := srv.target("sdvn")
+				target.Commands = cmds
+				return sshRunCmd(context.Background(), app, target)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds := []CommandConfig{{Run: take, Take: true}, {Run: status, AllowFailure: true}}
+
+			output, results, err := tt.run(cmds)
+			if err != nil {
+				t.Fatalf("run error = %v", err)
+			}
+			if len(results) != 2 {
+				t.Fatalf("results = %+v, want 2", results)
+			}
+
+			ok, failed := results[0], results[1]
+			if ok.Command != take || ok.Attempt != 1 || ok.Stdout != "taken\n" || ok.Stderr != "warn\n" || ok.ExitStatus != 0 || !ok.Take || ok.Error != "" {
+				t.Errorf("first result = %+v", ok)
+			}
+			if ok.StartTime.IsZero() || ok.EndTime.Before(ok.StartTime) || ok.DurationMs != ok.EndTime.Sub(ok.StartTime).Milliseconds() {
+				t.Errorf("first result times = %s, %s, %dms", ok.StartTime, ok.EndTime, ok.DurationMs)
+			}
+			if failed.Command != status || failed.Stdout != "" || failed.Stderr != "degraded\n" || failed.ExitStatus != 3 || failed.Take ||
+				!failed.AllowedFailure || !strings.Contains(failed.Error, "3") {
+				t.Errorf("second result = %+v", failed)
+			}
+
+			// the legacy text output still has both commands
+			for _, want := range []string{"Command: " + take, "taken", "Command: " + status, "degraded", "[ALLOWED FAILURE]"} {
+				if !strings.Contains(output, want) {
+					t.Errorf("output = %q, want %q in it", output, want)
+				}
+			}
+		})
+	}
+}
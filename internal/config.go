@@ -2,61 +2,317 @@ package internal
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 )
 
 type HostSSHConfig struct {
-	User string
-	Pass string
+	User          string
+	Pass          string // optional unless the host authenticates with password or keyboard-interactive
+	KeyPassphrase string // passphrase of the host's key_file, if it is encrypted
 }
 
 type HostConfig struct {
-	IP            string   `mapstructure:"ip"`
-	Commands      []string `mapstructure:"commands"`
-	BackgroundCmd string   `mapstructure:"background"`
+	IP            string          `mapstructure:"ip"`
+	Commands      []CommandConfig `mapstructure:"commands"`
+	BackgroundCmd string          `mapstructure:"background"`
+
+	// used when the hosts map has no entry for this section
+	Artifacts []string       `mapstructure:"artifacts"`
+	Uploads   []UploadConfig `mapstructure:"uploads"`
+	WorkDir   string         `mapstructure:"workdir"`
+
+	// keep running the following steps when this host's commands fail (legacy pipeline only)
+	ContinueOnError bool `mapstructure:"continue_on_error"`
+
+	// assertions on this host's command output, and the parsers that add it to the route
+	// timeline (legacy pipeline only)
+	Expect []ExpectConfig `mapstructure:"expect"`
+	Parse  []string       `mapstructure:"parse"`
+
+	// Capture the background command's output into the job result, within the limits below
+	// (only used when the pipeline is built from the host sections)
+	CaptureBackground  bool `mapstructure:"capture_background"`
+	BackgroundMaxBytes int  `mapstructure:"background_max_bytes"`
+	BackgroundMaxLines int  `mapstructure:"background_max_lines"`
+}
+
+// HostEntry is one named SSH host of the hosts map.
+type HostEntry struct {
+	Name       string      `mapstructure:"-"`          // the key of the hosts map
+	Address    string      `mapstructure:"address"`    // IP or hostname
+	Port       int         `mapstructure:"port"`       // defaults to 22
+	Credential string      `mapstructure:"credential"` // <CREDENTIAL>_SSH_USER/_SSH_PASS in .env, defaults to the host name
+	Auth       []string    `mapstructure:"auth"`       // auth methods in the order they are tried, defaults to [password]
+	KeyFile    string      `mapstructure:"key_file"`   // private key for the publickey method
+	ProxyJump  []string    `mapstructure:"proxy_jump"` // hosts to tunnel through, first hop first
+	Options    HostOptions `mapstructure:"options"`
+
+	// Remote files (glob patterns, relative to the working directory) downloaded over SFTP after each
+	// ssh stage on the host
+	Artifacts []string `mapstructure:"artifacts"`
+
+	// Files copied over SFTP before the commands of each ssh and ssh-background stage on the host,
+	// and the directory the commands run in: empty for the login directory, "temp" for a per-run
+	// directory that is removed when the run ends, or a path
+	Uploads []UploadConfig `mapstructure:"uploads"`
+	WorkDir string         `mapstructure:"workdir"`
+
+	// IANA time zone of the host's log timestamps that carry none, e.g. "America/New_York";
+	// defaults to the runner's local zone
+	LogTimezone string `mapstructure:"log_timezone"`
+
+	logLocation *time.Location
+}
+
+// SSH auth methods a host can list
+const (
+	AuthPassword            = "password"
+	AuthPublicKey           = "publickey"            // the host's key_file
+	AuthAgent               = "agent"                // keys of the agent at SSH_AUTH_SOCK
+	AuthKeyboardInteractive = "keyboard-interactive" // answers the server's prompts with the password
+)
+
+// usesPassword reports whether any of the host's auth methods needs the password.
+func (h HostEntry) usesPassword() bool {
+	return slices.Contains(h.Auth, AuthPassword) || slices.Contains(h.Auth, AuthKeyboardInteractive)
 }
 
+// HostOptions are per-host connection settings.
+type HostOptions struct {
+	ConnectTimeout   time.Duration `mapstructure:"connect_timeout"`   // TCP connect, defaults to 10s
+	HandshakeTimeout time.Duration `mapstructure:"handshake_timeout"` // SSH handshake and auth, defaults to 15s
+
+	// Keepalive requests are sent every KeepaliveInterval (default 30s, negative disables them);
+	// the connection is closed and the job fails after KeepaliveMaxMissed (default 3) go unanswered
+	KeepaliveInterval  time.Duration `mapstructure:"keepalive_interval"`
+	KeepaliveMaxMissed int           `mapstructure:"keepalive_max_missed"`
+
+	// Allowed algorithms, e.g. for older appliances; empty uses the SSH library defaults
+	Ciphers       []string `mapstructure:"ciphers"`
+	KexAlgorithms []string `mapstructure:"kex_algorithms"`
+
+	// Pinned host key fingerprints ("SHA256:..." as printed by ssh-keygen -l); when set they are
+	// checked instead of known_hosts
+	HostKeyFingerprints []string `mapstructure:"host_key_fingerprints"`
+}
+
+// SSHConfig holds the SSH settings shared by all hosts.
+type SSHConfig struct {
+	KnownHosts      string `mapstructure:"known_hosts"`        // defaults to ~/.ssh/known_hosts
+	TrustOnFirstUse bool   `mapstructure:"trust_on_first_use"` // record the key of hosts not in known_hosts
+}
+
+const (
+	defaultSSHPort            = 22
+	defaultConnectTimeout     = 10 * time.Second
+	defaultHandshakeTimeout   = 15 * time.Second
+	defaultKeepaliveInterval  = 30 * time.Second
+	defaultKeepaliveMaxMissed = 3
+)
+
+// Default limits for captured background output
+const (
+	defaultBackgroundMaxBytes = 1 << 20
+	defaultBackgroundMaxLines = 10000
+)
+
+// OutputConfig limits the command output a job keeps. Output over a limit keeps its head and tail
+// around a truncation marker.
+type OutputConfig struct {
+	MaxCommandBytes int  `mapstructure:"max_command_bytes"` // per stream (stdout, stderr) of a command, defaults to 1 MiB
+	MaxJobBytes     int  `mapstructure:"max_job_bytes"`     // all commands of a job together, defaults to 16 MiB
+	SpillToDisk     bool `mapstructure:"spill_to_disk"`     // write truncated output in full to an artifact file
+}
+
+// TestWindowConfig widens the test window, to catch logs written just before or after the test.
+type TestWindowConfig struct {
+	PaddingBefore time.Duration `mapstructure:"padding_before"`
+	PaddingAfter  time.Duration `mapstructure:"padding_after"`
+}
+
+// ArtifactsConfig sets where job artifacts are stored, one directory per job.
+type ArtifactsConfig struct {
+	Dir string `mapstructure:"dir"` // defaults to "artifacts"
+
+	// How far a host's clock may lag the runner's: remote files last modified more than this before
+	// the test window are skipped as left over from earlier runs. Defaults to 1m; negative keeps
+	// every match.
+	ClockSkew time.Duration `mapstructure:"clock_skew"`
+}
+
+const (
+	defaultMaxCommandBytes = 1 << 20
+	defaultMaxJobBytes     = 16 << 20
+	defaultArtifactsDir    = "artifacts"
+	defaultClockSkew       = time.Minute
+)
+
 type LocalConfig struct {
-	Commands []string `mapstructure:"commands"`
+	Commands        []CommandConfig `mapstructure:"commands"`
+	ContinueOnError bool            `mapstructure:"continue_on_error"`
+	Expect          []ExpectConfig  `mapstructure:"expect"`
+	Parse           []string        `mapstructure:"parse"`
+
+	// Search the slab logs in Elasticsearch instead of running the commands
+	Search *SlabSearchConfig `mapstructure:"search"`
+}
+
+// ScheduleConfig controls how stored schedules are restored on startup.
+type ScheduleConfig struct {
+	// CatchUp decides what happens to schedules whose time passed while the service was down:
+	// "skip" (default) marks them missed, "run" runs them immediately,
+	// "next_slot" moves them to the next slot without a conflict.
+	CatchUp string `mapstructure:"catch_up"`
+}
+
+// ProfileConfig is a named route test definition. Its params fill the {{.Name}} placeholders of the
+// pipeline commands; param names match the placeholders case-insensitively, e.g. slab → {{.Slab}}
+// and mcastgroup → {{.McastGroup}}.
+type ProfileConfig struct {
+	Name        string            `mapstructure:"-" json:"name"` // the key of the profiles map
+	Description string            `mapstructure:"description" json:"description,omitempty"`
+	Params      map[string]string `mapstructure:"params" json:"params"`
+}
+
+// Pipeline stage types
+const (
+	StageSSH            = "ssh"             // run commands on a host, one session per command
+	StageSSHBackground  = "ssh-background"  // start a long-running command on a host and keep it running
+	StageStopBackground = "stop-background" // stop the background command started on a host
+	StageLocal          = "local"           // run commands on the runner itself
+	StageSlabLogs       = "slab-logs"       // search the slab logs of the run in Elasticsearch
+)
+
+// StageConfig is one entry of the ordered pipeline executed by every run.
+type StageConfig struct {
+	Name     string            `mapstructure:"name" json:"name"`
+	Type     string            `mapstructure:"type" json:"type"`
+	Host     string            `mapstructure:"host" json:"host,omitempty"` // host reference; a plain label for local stages
+	Commands []CommandConfig   `mapstructure:"commands" json:"-"`          // ssh and local stages
+	Command  string            `mapstructure:"command" json:"-"`           // ssh-background stages
+	Search   *SlabSearchConfig `mapstructure:"search" json:"-"`            // slab-logs stages
+
+	// run the following stages even when this one fails; the job is still reported as failed
+	ContinueOnError bool `mapstructure:"continue_on_error" json:"continueOnError,omitempty"`
+
+	// the stage performs the test; the test stages make up the test window
+	Test bool `mapstructure:"test" json:"test,omitempty"`
+
+	// assertions on the stage's outcome; together they decide the job's PASS/FAIL verdict
+	Expect []ExpectConfig `mapstructure:"expect" json:"-"`
+
+	// log parsers (e.g. magrtrsrv, magclientsrv, lwrp) that turn the stage's output into route
+	// timeline events; slab-logs stages default to lwrp
+	Parse []string `mapstructure:"parse" json:"parse,omitempty"`
+
+	// ssh-background: capture the command's output into the stage result, within these limits
+	Capture  bool `mapstructure:"capture" json:"-"`
+	MaxBytes int  `mapstructure:"max_bytes" json:"-"`
+	MaxLines int  `mapstructure:"max_lines" json:"-"`
+
+	// the legacy Start Log stage: its captured output also fills JobResult.SdvnTailOutput
+	tailOutput bool
 }
 
 type FileConfig struct {
-	Scheduler HostConfig  `mapstructure:"scheduler"`
-	Sdvn      HostConfig  `mapstructure:"sdvn"`
-	Slab      LocalConfig `mapstructure:"slab"`
+	// Hosts are the SSH hosts that pipeline stages refer to by name. The scheduler and sdvn
+	// sections are added as hosts "scheduler" and "sdvn" unless the map already has them.
+	Hosts map[string]HostEntry `mapstructure:"hosts"`
+	SSH   SSHConfig            `mapstructure:"ssh"`
+
+	Scheduler HostConfig      `mapstructure:"scheduler"`
+	Sdvn      HostConfig      `mapstructure:"sdvn"`
+	Slab      LocalConfig     `mapstructure:"slab"`
+	Schedules ScheduleConfig  `mapstructure:"schedules"`
+	Output    OutputConfig    `mapstructure:"output"`
+	Artifacts ArtifactsConfig `mapstructure:"artifacts"`
+
+	// TestWindow pads the test window that log-gathering stages keep
+	TestWindow TestWindowConfig `mapstructure:"test_window"`
+
+	// Profiles are the route tests a run can select; DefaultProfile is used when a run names none.
+	Profiles       map[string]ProfileConfig `mapstructure:"profiles"`
+	DefaultProfile string                   `mapstructure:"default_profile"`
+
+	// Pipeline lists the stages of a run in order. When it is omitted, the classic five steps are
+	// built from the scheduler, sdvn and slab sections.
+	Pipeline []StageConfig `mapstructure:"pipeline"`
+
+	// Finally lists cleanup stages that always run after the pipeline, even after a failure or a
+	// stop, once the pipeline's background commands are stopped.
+	Finally []StageConfig `mapstructure:"finally"`
+}
+
+// AllStages returns the pipeline stages followed by the finally stages.
+func (cfg FileConfig) AllStages() []StageConfig {
+	return append(slices.Clone(cfg.Pipeline), cfg.Finally...)
 }
 
 // AppConfig merges .env-based SSH credentials and file config.
 type AppConfig struct {
-	SchedulerSSH HostSSHConfig
-	SdvnSSH      HostSSHConfig
-	File         FileConfig
+	Credentials map[string]HostSSHConfig // host name → SSH credentials
+	File        FileConfig
 }
 
-// Loads both credential sets from the .env file
-func LoadSSHCredentials() (*AppConfig, error) {
+// Loads the SSH credentials of every host from the .env file (or the environment).
+// Each host reads <CREDENTIAL>_SSH_USER, <CREDENTIAL>_SSH_PASS and <CREDENTIAL>_SSH_KEY_PASSPHRASE,
+// where CREDENTIAL is the host's credential reference upper-cased, with anything other than letters
+// and digits replaced by "_". The password is only required by the password and keyboard-interactive
+// auth methods.
+func LoadSSHCredentials(hosts map[string]HostEntry) (map[string]HostSSHConfig, error) {
 	_ = godotenv.Load(".env")
 
-	schedulerUser := os.Getenv("SCHEDULER_SSH_USER")
-	schedulerPass := os.Getenv("SCHEDULER_SSH_PASS")
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	creds := make(map[string]HostSSHConfig, len(hosts))
 
-	sdvnUser := os.Getenv("SDVN_SSH_USER")
-	sdvnPass := os.Getenv("SDVN_SSH_PASS")
+	for _, name := range names {
+		prefix := envPrefix(hosts[name].Credential)
 
-	if schedulerUser == "" || schedulerPass == "" {
-		return nil, fmt.Errorf("SCHEDULER_SSH_USER or SCHEDULER_SSH_PASS not set in .env")
-	}
-	if sdvnUser == "" || sdvnPass == "" {
-		return nil, fmt.Errorf("SDVN_SSH_USER or SDVN_SSH_PASS not set in .env")
+		user := os.Getenv(prefix + "_SSH_USER")
+		pass := os.Getenv(prefix + "_SSH_PASS")
+
+		if user == "" {
+			return nil, fmt.Errorf("%s_SSH_USER not set in .env (host %q)", prefix, name)
+		}
+		if pass == "" && hosts[name].usesPassword() {
+			return nil, fmt.Errorf("%s_SSH_PASS not set in .env (host %q)", prefix, name)
+		}
+
+		creds[name] = HostSSHConfig{
+			User:          user,
+			Pass:          pass,
+			KeyPassphrase: os.Getenv(prefix + "_SSH_KEY_PASSPHRASE"),
+		}
 	}
 
-	return &AppConfig{
-		SchedulerSSH: HostSSHConfig{User: schedulerUser, Pass: schedulerPass},
-		SdvnSSH:      HostSSHConfig{User: sdvnUser, Pass: sdvnPass},
-	}, nil
+	return creds, nil
+}
+
+// envPrefix turns a credential reference into its environment variable prefix.
+func envPrefix(ref string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(ref))
 }
 
 // Loads FileConfig from config.yaml (or config.json, etc)
@@ -70,9 +326,398 @@ func LoadFileConfig(configPath string) (FileConfig, error) {
 		return cfg, fmt.Errorf("error reading config: %w", err)
 	}
 
-	if err := v.Unmarshal(&cfg); err != nil {
+	hooks := mapstructure.ComposeDecodeHookFunc(
+		commandDecodeHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)
+
+	if err := v.Unmarshal(&cfg, viper.DecodeHook(hooks)); err != nil {
 		return cfg, fmt.Errorf("error parsing config: %w", err)
 	}
 
+	if err := resolveHosts(&cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing config: %w", err)
+	}
+
+	if len(cfg.Pipeline) == 0 {
+		cfg.Pipeline = legacyPipeline(cfg)
+	}
+
+	if err := validatePipeline(cfg.Pipeline, cfg.Hosts); err != nil {
+		return cfg, fmt.Errorf("error parsing config: %w", err)
+	}
+
+	if err := validatePipeline(cfg.Finally, cfg.Hosts); err != nil {
+		return cfg, fmt.Errorf("error parsing config: finally: %w", err)
+	}
+
+	if err := resolveProfiles(&cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing config: %w", err)
+	}
+
+	if err := resolveOutput(&cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing config: %w", err)
+	}
+
+	if cfg.TestWindow.PaddingBefore < 0 || cfg.TestWindow.PaddingAfter < 0 {
+		return cfg, fmt.Errorf("error parsing config: negative test_window padding")
+	}
+
+	switch cfg.Schedules.CatchUp {
+	case "":
+		cfg.Schedules.CatchUp = CatchUpSkip
+	case CatchUpSkip, CatchUpRun, CatchUpNextSlot:
+	default:
+		return cfg, fmt.Errorf("error parsing config: unknown schedules.catch_up %q", cfg.Schedules.CatchUp)
+	}
+
 	return cfg, nil
 }
+
+// resolveHosts adds the scheduler and sdvn sections to the hosts map and fills in host defaults.
+func resolveHosts(cfg *FileConfig) error {
+	if cfg.Hosts == nil {
+		cfg.Hosts = map[string]HostEntry{}
+	}
+
+	legacy := map[string]HostConfig{"scheduler": cfg.Scheduler, "sdvn": cfg.Sdvn}
+	for name, section := range legacy {
+		if _, ok := cfg.Hosts[name]; !ok && section.IP != "" {
+			cfg.Hosts[name] = HostEntry{
+				Address:   section.IP,
+				Artifacts: section.Artifacts,
+				Uploads:   section.Uploads,
+				WorkDir:   section.WorkDir,
+			}
+		}
+	}
+
+	if err := resolveKnownHosts(&cfg.SSH); err != nil {
+		return err
+	}
+
+	for name, host := range cfg.Hosts {
+		if host.Address == "" {
+			return fmt.Errorf("host %q: no address", name)
+		}
+
+		for i, hop := range host.ProxyJump {
+			hop = strings.ToLower(hop)
+			jump, ok := cfg.Hosts[hop]
+			if !ok || hop == name {
+				return fmt.Errorf("host %q: unknown proxy_jump host %q", name, hop)
+			}
+			if len(jump.ProxyJump) > 0 {
+				return fmt.Errorf("host %q: jump host %q has its own proxy_jump; list every hop on %q instead", name, hop, name)
+			}
+			host.ProxyJump[i] = hop
+		}
+
+		for _, pattern := range host.Artifacts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("host %q: artifact pattern %q: %w", name, pattern, err)
+			}
+		}
+
+		for i, fp := range host.Options.HostKeyFingerprints {
+			if !strings.HasPrefix(fp, "SHA256:") {
+				host.Options.HostKeyFingerprints[i] = "SHA256:" + fp
+			}
+		}
+
+		host.Name = name
+		if host.Port == 0 {
+			host.Port = defaultSSHPort
+		}
+		if host.Credential == "" {
+			host.Credential = name
+		}
+		if err := resolveHostOptions(&host.Options); err != nil {
+			return fmt.Errorf("host %q: %w", name, err)
+		}
+
+		if err := resolveAuth(&host); err != nil {
+			return fmt.Errorf("host %q: %w", name, err)
+		}
+
+		if err := resolveUploads(&host); err != nil {
+			return fmt.Errorf("host %q: %w", name, err)
+		}
+
+		host.logLocation = time.Local
+		if host.LogTimezone != "" {
+			loc, err := time.LoadLocation(host.LogTimezone)
+			if err != nil {
+				return fmt.Errorf("host %q: log_timezone: %w", name, err)
+			}
+			host.logLocation = loc
+		}
+
+		cfg.Hosts[name] = host
+	}
+
+	return nil
+}
+
+// resolveProfiles names every profile and checks the default profile exists.
+func resolveProfiles(cfg *FileConfig) error {
+	for name, profile := range cfg.Profiles {
+		profile.Name = name
+		cfg.Profiles[name] = profile
+	}
+
+	// profile names are matched like the keys of the profiles map, which the config loader lower-cases
+	cfg.DefaultProfile = strings.ToLower(cfg.DefaultProfile)
+
+	if _, ok := cfg.Profiles[cfg.DefaultProfile]; cfg.DefaultProfile != "" && !ok {
+		return fmt.Errorf("unknown default_profile %q", cfg.DefaultProfile)
+	}
+
+	return nil
+}
+
+// resolveOutput fills in the default output limits and artifact directory.
+func resolveOutput(cfg *FileConfig) error {
+	if cfg.Output.MaxCommandBytes < 0 || cfg.Output.MaxJobBytes < 0 {
+		return fmt.Errorf("output: negative max_command_bytes or max_job_bytes")
+	}
+	if cfg.Output.MaxCommandBytes == 0 {
+		cfg.Output.MaxCommandBytes = defaultMaxCommandBytes
+	}
+	if cfg.Output.MaxJobBytes == 0 {
+		cfg.Output.MaxJobBytes = defaultMaxJobBytes
+	}
+
+	if cfg.Artifacts.Dir == "" {
+		cfg.Artifacts.Dir = defaultArtifactsDir
+	}
+	if cfg.Artifacts.ClockSkew == 0 {
+		cfg.Artifacts.ClockSkew = defaultClockSkew
+	}
+
+	var err error
+	if cfg.Artifacts.Dir, err = expandHome(cfg.Artifacts.Dir); err != nil {
+		return fmt.Errorf("artifacts.dir: %w", err)
+	}
+
+	return nil
+}
+
+// resolveHostOptions fills in the default connection settings and checks the algorithm names.
+func resolveHostOptions(opts *HostOptions) error {
+	if opts.ConnectTimeout == 0 {
+		opts.ConnectTimeout = defaultConnectTimeout
+	}
+	if opts.HandshakeTimeout == 0 {
+		opts.HandshakeTimeout = defaultHandshakeTimeout
+	}
+	if opts.KeepaliveInterval == 0 {
+		opts.KeepaliveInterval = defaultKeepaliveInterval
+	}
+	if opts.KeepaliveMaxMissed == 0 {
+		opts.KeepaliveMaxMissed = defaultKeepaliveMaxMissed
+	}
+
+	return validateAlgorithms(*opts)
+}
+
+// resolveAuth defaults and checks the auth methods of a host.
+func resolveAuth(host *HostEntry) error {
+	if len(host.Auth) == 0 {
+		host.Auth = []string{AuthPassword}
+	}
+
+	for i, method := range host.Auth {
+		host.Auth[i] = strings.ToLower(method)
+
+		switch host.Auth[i] {
+		case AuthPassword, AuthAgent, AuthKeyboardInteractive:
+		case AuthPublicKey:
+			if host.KeyFile == "" {
+				return fmt.Errorf("auth method publickey needs a key_file")
+			}
+		default:
+			return fmt.Errorf("unknown auth method %q", method)
+		}
+	}
+
+	if host.KeyFile != "" {
+		var err error
+		if host.KeyFile, err = expandHome(host.KeyFile); err != nil {
+			return fmt.Errorf("key_file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// resolveKnownHosts defaults the known_hosts path and expands a leading "~/".
+func resolveKnownHosts(cfg *SSHConfig) error {
+	if cfg.KnownHosts == "" {
+		cfg.KnownHosts = "~/.ssh/known_hosts"
+	}
+
+	var err error
+	if cfg.KnownHosts, err = expandHome(cfg.KnownHosts); err != nil {
+		return fmt.Errorf("ssh.known_hosts: %w", err)
+	}
+
+	return nil
+}
+
+// expandHome replaces a leading "~/" of path with the user's home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, rest), nil
+}
+
+// legacyPipeline builds the classic five steps from the scheduler, sdvn and slab sections:
+// start the log tail on sdvn, run the scheduler commands, stop the tail, run the sdvn commands
+// and run the local slab commands.
+func legacyPipeline(cfg FileConfig) []StageConfig {
+	scheduler := StageConfig{
+		Name: "Scheduler", Type: StageSSH, Host: "scheduler", Test: true,
+		Commands: cfg.Scheduler.Commands, ContinueOnError: cfg.Scheduler.ContinueOnError,
+		Expect: cfg.Scheduler.Expect, Parse: cfg.Scheduler.Parse,
+	}
+	sdvn := StageConfig{
+		Name: "SDVN", Type: StageSSH, Host: "sdvn",
+		Commands: cfg.Sdvn.Commands, ContinueOnError: cfg.Sdvn.ContinueOnError,
+		Expect: cfg.Sdvn.Expect, Parse: cfg.Sdvn.Parse,
+	}
+	slab := StageConfig{
+		Name: "Slab", Type: StageLocal, Host: "slab",
+		Commands: cfg.Slab.Commands, ContinueOnError: cfg.Slab.ContinueOnError,
+		Expect: cfg.Slab.Expect, Parse: cfg.Slab.Parse,
+	}
+	if cfg.Slab.Search != nil {
+		slab.Type = StageSlabLogs
+		slab.Search = cfg.Slab.Search
+	}
+
+	if cfg.Sdvn.BackgroundCmd == "" {
+		return []StageConfig{scheduler, sdvn, slab}
+	}
+
+	return []StageConfig{
+		{
+			Name:     "Start Log",
+			Type:     StageSSHBackground,
+			Host:     "sdvn",
+			Command:  cfg.Sdvn.BackgroundCmd,
+			Capture:  cfg.Sdvn.CaptureBackground,
+			MaxBytes: cfg.Sdvn.BackgroundMaxBytes,
+			MaxLines: cfg.Sdvn.BackgroundMaxLines,
+
+			tailOutput: true,
+		},
+		scheduler,
+		{Name: "Stop Log", Type: StageStopBackground, Host: "sdvn"},
+		sdvn,
+		slab,
+	}
+}
+
+// stdoutRedirectRx matches a shell redirect of stdout (> f, >> f, 1> f, &> f), but not 2> f.
+var stdoutRedirectRx = regexp.MustCompile(`(?:^|[\s;&|(])[1&]?>`)
+
+// validatePipeline checks every stage's type and host reference, fills in default names and
+// capture limits, and makes sure each stop-background stage follows a background stage on its host.
+func validatePipeline(stages []StageConfig, hosts map[string]HostEntry) error {
+	background := map[string]bool{}
+
+	for i := range stages {
+		stage := &stages[i]
+
+		// host names are matched like the keys of the hosts map, which the config loader lower-cases
+		stage.Host = strings.ToLower(stage.Host)
+
+		if stage.Name == "" {
+			stage.Name = fmt.Sprintf("%s %s", stage.Type, stage.Host)
+		}
+
+		if _, ok := hosts[stage.Host]; !ok && stage.Type != StageLocal && stage.Type != StageSlabLogs {
+			return fmt.Errorf("stage %q: unknown host %q", stage.Name, stage.Host)
+		}
+
+		for _, cmd := range stage.Commands {
+			if cmd.Run == "" {
+				return fmt.Errorf("stage %q: command without run", stage.Name)
+			}
+			if cmd.Retries < 0 || cmd.Timeout < 0 || cmd.RetryDelay < 0 {
+				return fmt.Errorf("stage %q: command %q: negative timeout, retries or retry_delay", stage.Name, cmd.Run)
+			}
+		}
+
+		for j := range stage.Expect {
+			rule := &stage.Expect[j]
+			if err := validateExpect(rule); err != nil {
+				return fmt.Errorf("stage %q: %w", stage.Name, err)
+			}
+			if rule.ExitCode != nil && stage.Type != StageSSH && stage.Type != StageLocal {
+				return fmt.Errorf("stage %q: expect: exit_code needs an ssh or local stage", stage.Name)
+			}
+		}
+
+		if err := validateParsers(stage.Parse); err != nil {
+			return fmt.Errorf("stage %q: %w", stage.Name, err)
+		}
+
+		switch stage.Type {
+		case StageSSH, StageLocal:
+		case StageSlabLogs:
+			if stage.Parse == nil {
+				stage.Parse = []string{"lwrp"}
+			}
+			if stage.Search == nil {
+				return fmt.Errorf("stage %q: no search settings", stage.Name)
+			}
+			if err := resolveSlabSearch(stage.Search); err != nil {
+				return fmt.Errorf("stage %q: %w", stage.Name, err)
+			}
+		case StageSSHBackground:
+			if stage.Command == "" {
+				return fmt.Errorf("stage %q: no background command", stage.Name)
+			}
+			if background[stage.Host] {
+				return fmt.Errorf("stage %q: host %q already runs a background command; stop it first", stage.Name, stage.Host)
+			}
+			if stage.MaxBytes == 0 {
+				stage.MaxBytes = defaultBackgroundMaxBytes
+			}
+			if stage.MaxLines == 0 {
+				stage.MaxLines = defaultBackgroundMaxLines
+			}
+			if (len(stage.Expect) > 0 || len(stage.Parse) > 0) && !stage.Capture {
+				return fmt.Errorf("stage %q: expect and parse need capture on a background stage", stage.Name)
+			}
+			if stage.Capture && stdoutRedirectRx.MatchString(stage.Command) {
+				slog.Warn("background output is captured, but the command redirects its stdout; pipe through tee to keep a copy",
+					"stage", stage.Name, "command", stage.Command)
+			}
+			background[stage.Host] = true
+		case StageStopBackground:
+			if !background[stage.Host] {
+				return fmt.Errorf("stage %q: no background stage on host %q before it", stage.Name, stage.Host)
+			}
+			background[stage.Host] = false
+			if len(stage.Expect) > 0 || len(stage.Parse) > 0 {
+				return fmt.Errorf("stage %q: expect or parse on a stop-background stage; put it on the background stage", stage.Name)
+			}
+		default:
+			return fmt.Errorf("stage %q: unknown type %q", stage.Name, stage.Type)
+		}
+	}
+
+	return nil
+}
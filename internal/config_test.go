@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig writes yaml to a config file and loads it.
+func loadTestConfig(t *testing.T, yaml string) (FileConfig, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadFileConfig(path)
+}
+
+func TestLoadFileConfigHosts(t *testing.T) {
+	const pipeline = `
+pipeline:
+  - name: Slab
+    type: local
+    commands: ["true"]
+`
+
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+		check   func(t *testing.T, hosts map[string]HostEntry)
+	}{
+		{
+			name: "defaults",
+			yaml: `
+hosts:
+  Router-A:
+    address: 10.9.4.21
+` + pipeline,
+			check: func(t *testing.T, hosts map[string]HostEntry) {
+				host, ok := hosts["router-a"]
+				if !ok {
+					t.Fatalf("hosts = %v, want router-a", hosts)
+				}
+				if host.Name != "router-a" || host.Port != 22 || host.Credential != "router-a" {
+					t.Errorf("host = %+v", host)
+				}
+			},
+		},
+		{
+			name: "legacy sections",
+			yaml: `
+scheduler:
+  ip: 10.9.4.10
+  workdir: temp
+sdvn:
+  ip: 10.9.4.11
+hosts:
+  sdvn:
+    address: 10.9.4.99
+    port: 2222
+` + pipeline,
+			check: func(t *testing.T, hosts map[string]HostEntry) {
+				if host := hosts["scheduler"]; host.Address != "10.9.4.10" || host.WorkDir != "temp" || host.Port != 22 {
+					t.Errorf("scheduler = %+v", host)
+				}
+				// a hosts entry wins over the section of the same name
+				if host := hosts["sdvn"]; host.Address != "10.9.4.99" || host.Port != 2222 {
+					t.Errorf("sdvn = %+v", host)
+				}
+			},
+		},
+		{
+			name: "no address",
+			yaml: `
+hosts:
+  router:
+    port: 22
+` + pipeline,
+			wantErr: `host "router": no address`,
+		},
+		{
+			name: "credential",
+			yaml: `
+hosts:
+  router-a:
+    address: 10.9.4.21
+    credential: routers
+  router-b:
+    address: 10.9.4.22
+    credential: routers
+` + pipeline,
+			check: func(t *testing.T, hosts map[string]HostEntry) {
+				if hosts["router-a"].Credential != "routers" || hosts["router-b"].Credential != "routers" {
+					t.Errorf("hosts = %+v", hosts)
+				}
+			},
+		},
+		{
+			name: "connection options",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+    port: 2222
+    options:
+      connect_timeout: 3s
+      keepalive_interval: -1s
+      ciphers: [aes128-cbc]
+  switch:
+    address: 10.9.4.22
+` + pipeline,
+			check: func(t *testing.T, hosts map[string]HostEntry) {
+				opts := hosts["router"].Options
+				if opts.ConnectTimeout != 3*time.Second || opts.HandshakeTimeout != defaultHandshakeTimeout || opts.KeepaliveInterval != -time.Second {
+					t.Errorf("router options = %+v", opts)
+				}
+				want := HostOptions{
+					ConnectTimeout:     defaultConnectTimeout,
+					HandshakeTimeout:   defaultHandshakeTimeout,
+					KeepaliveInterval:  defaultKeepaliveInterval,
+					KeepaliveMaxMissed: defaultKeepaliveMaxMissed,
+				}
+				if opts := hosts["switch"].Options; fmt.Sprint(opts) != fmt.Sprint(want) {
+					t.Errorf("switch options = %+v, want %+v", opts, want)
+				}
+			},
+		},
+		{
+			name: "unsupported cipher",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+    options:
+      ciphers: [rot13]
+` + pipeline,
+			wantErr: `unsupported cipher "rot13"`,
+		},
+		{
+			name: "auth methods",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+    auth: [Agent, publickey, password]
+    key_file: /etc/routetest/id_ed25519
+` + pipeline,
+			check: func(t *testing.T, hosts map[string]HostEntry) {
+				if host := hosts["router"]; fmt.Sprint(host.Auth) != "[agent publickey password]" || host.KeyFile != "/etc/routetest/id_ed25519" {
+					t.Errorf("host = %+v", host)
+				}
+			},
+		},
+		{
+			name: "default auth",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+` + pipeline,
+			check: func(t *testing.T, hosts map[string]HostEntry) {
+				if auth := hosts["router"].Auth; fmt.Sprint(auth) != "[password]" {
+					t.Errorf("auth = %v, want [password]", auth)
+				}
+			},
+		},
+		{
+			name: "unknown auth method",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+    auth: [password, kerberos]
+` + pipeline,
+			wantErr: `unknown auth method "kerberos"`,
+		},
+		{
+			name: "publickey without key file",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+    auth: [publickey]
+` + pipeline,
+			wantErr: "needs a key_file",
+		},
+		{
+			name: "proxy jump",
+			yaml: `
+hosts:
+  bastion:
+    address: 10.9.0.1
+    credential: jump
+  router:
+    address: 10.9.4.21
+    proxy_jump: [Bastion]
+` + pipeline,
+			check: func(t *testing.T, hosts map[string]HostEntry) {
+				host := hosts["router"]
+				if len(host.ProxyJump) != 1 || host.ProxyJump[0] != "bastion" {
+					t.Errorf("proxy_jump = %v, want [bastion]", host.ProxyJump)
+				}
+				if hosts["bastion"].Credential != "jump" {
+					t.Errorf("bastion credential = %q", hosts["bastion"].Credential)
+				}
+			},
+		},
+		{
+			name: "unknown proxy jump",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+    proxy_jump: [bastion]
+` + pipeline,
+			wantErr: `unknown proxy_jump host "bastion"`,
+		},
+		{
+			name: "jump through itself",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+    proxy_jump: [router]
+` + pipeline,
+			wantErr: `unknown proxy_jump host "router"`,
+		},
+		{
+			name: "nested proxy jump",
+			yaml: `
+hosts:
+  outer:
+    address: 10.9.0.1
+  bastion:
+    address: 10.9.0.2
+    proxy_jump: [outer]
+  router:
+    address: 10.9.4.21
+    proxy_jump: [bastion]
+` + pipeline,
+			wantErr: `jump host "bastion" has its own proxy_jump`,
+		},
+		{
+			name: "stage on an unknown host",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+pipeline:
+  - name: Take
+    type: ssh
+    host: scheduler
+    commands: ["take"]
+`,
+			wantErr: `stage "Take": unknown host "scheduler"`,
+		},
+		{
+			name: "finally stage on an unknown host",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+` + pipeline + `
+finally:
+  - name: Unlock
+    type: ssh
+    host: scheduler
+    commands: ["unlock"]
+`,
+			wantErr: `finally: stage "Unlock": unknown host "scheduler"`,
+		},
+		{
+			name: "stage host case",
+			yaml: `
+hosts:
+  router:
+    address: 10.9.4.21
+pipeline:
+  - name: Take
+    type: ssh
+    host: Router
+    commands: ["take"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.yaml)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("LoadFileConfig() error = %v", err)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadFileConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.check != nil {
+				tt.check(t, cfg.Hosts)
+			}
+		})
+	}
+}
+
+func TestLoadFileConfigProfiles(t *testing.T) {
+	const pipeline = `
+pipeline:
+  - name: Slab
+    type: local
+    commands: ["slab.py -slab {{.Slab}}"]
+`
+
+	tests := []struct {
+		name        string
+		yaml        string
+		wantDefault string
+		wantErr     string
+	}{
+		{
+			name: "default profile",
+			yaml: `
+default_profile: Studio-A
+profiles:
+  studio-a:
+    params: {slab: slab017}
+` + pipeline,
+			wantDefault: "studio-a",
+		},
+		{
+			name: "unknown default profile",
+			yaml: `
+default_profile: studio-b
+profiles:
+  studio-a:
+    params: {slab: slab017}
+` + pipeline,
+			wantErr: `unknown default_profile "studio-b"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.yaml)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadFileConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFileConfig() error = %v", err)
+			}
+			if cfg.DefaultProfile != tt.wantDefault {
+				t.Errorf("DefaultProfile = %q, want %q", cfg.DefaultProfile, tt.wantDefault)
+			}
+			for name, profile := range cfg.Profiles {
+				if profile.Name != name {
+					t.Errorf("profile %q is named %q", name, profile.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFileConfigCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		want     []CommandConfig
+		wantErr  string
+	}{
+		{
+			name:     "plain strings",
+			commands: `["take.sh 6", "status"]`,
+			want:     []CommandConfig{{Run: "take.sh 6"}, {Run: "status"}},
+		},
+		{
+			name: "policies",
+			commands: `
+      - status
+      - run: take.sh 6
+        timeout: 5m
+        retries: 2
+        retry_delay: 10s
+        allow_failure: true
+        take: true`,
+			want: []CommandConfig{
+				{Run: "status"},
+				{Run: "take.sh 6", Timeout: 5 * time.Minute, Retries: 2, RetryDelay: 10 * time.Second, AllowFailure: true, Take: true},
+			},
+		},
+		{name: "negative retries", commands: `[{run: status, retries: -1}]`, wantErr: "negative timeout, retries or retry_delay"},
+		{name: "negative timeout", commands: `[{run: status, timeout: -1s}]`, wantErr: "negative timeout, retries or retry_delay"},
+		{name: "no run", commands: `[{timeout: 1s}]`, wantErr: "command without run"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, `
+pipeline:
+  - name: Slab
+    type: local
+    commands: `+tt.commands+"\n")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadFileConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFileConfig() error = %v", err)
+			}
+			if got := cfg.Pipeline[0].Commands; fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("commands = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSlabIndex         = "<log-syslog-error-{now/d}>,<log-syslog-error-{now/d-1d}>"
+	defaultSlabSearchSize    = 10000
+	defaultSlabSearchTimeout = 30 * time.Second
+)
+
+// SlabSearchConfig is the Elasticsearch query of a slab-logs stage. Slab, Dst and Mcast are
+// command templates, e.g. "{{.Slab}}".
+type SlabSearchConfig struct {
+	URL        string        `mapstructure:"url"`        // Insite analytics Elasticsearch, e.g. http://10.9.0.69:9200
+	Index      string        `mapstructure:"index"`      // index pattern, defaults to today's and yesterday's log-syslog-error
+	Credential string        `mapstructure:"credential"` // <CREDENTIAL>_ES_USER/_ES_PASS or _ES_API_KEY in .env; empty for no auth
+	Insecure   bool          `mapstructure:"insecure"`   // skip TLS certificate verification
+	Timeout    time.Duration `mapstructure:"timeout"`    // per request, defaults to 30s
+	Size       int           `mapstructure:"size"`       // max log entries, defaults to 10000
+
+	Slab  string `mapstructure:"slab"`  // annotation.general.device_name of the slab
+	Dst   string `mapstructure:"dst"`   // slab output, matched as "DST <n>"
+	Mcast string `mapstructure:"mcast"` // multicast address
+}
+
+// LogEntry is one log line returned by a slab-logs stage.
+type LogEntry struct {
+	Timestamp time.Time
+	Device    string `json:",omitempty"`
+	Message   string
+}
+
+// resolveSlabSearch checks a slab-logs stage's search settings and fills in the defaults.
+func resolveSlabSearch(search *SlabSearchConfig) error {
+	if search.URL == "" {
+		return fmt.Errorf("search: no url")
+	}
+	if _, err := url.ParseRequestURI(search.URL); err != nil {
+		return fmt.Errorf("search: invalid url: %w", err)
+	}
+	if search.Slab == "" || search.Dst == "" || search.Mcast == "" {
+		return fmt.Errorf("search: slab, dst and mcast are required")
+	}
+
+	search.URL = strings.TrimRight(search.URL, "/")
+	if search.Index == "" {
+		search.Index = defaultSlabIndex
+	}
+	if search.Size == 0 {
+		search.Size = defaultSlabSearchSize
+	}
+	if search.Timeout == 0 {
+		search.Timeout = defaultSlabSearchTimeout
+	}
+
+	return nil
+}
+
+// slabQuery builds the bool query of the slab logs: the LwrpUpdated messages of the slab output
+// and multicast address, logged by the slab between from and to.
+func slabQuery(search SlabSearchConfig, from, to time.Time) map[string]any {
+	match := func(kind, query string) map[string]any {
+		return map[string]any{"multi_match": map[string]any{"type": kind, "query": query, "lenient": true}}
+	}
+
+	return map[string]any{
+		"size": search.Size,
+		"sort": []any{map[string]any{"@timestamp": "asc"}},
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": []any{
+					map[string]any{"bool": map[string]any{"must": []any{
+						match("best_fields", "LwrpUpdated"),
+						match("phrase", "DST "+search.Dst),
+						match("phrase", search.Mcast),
+					}}},
+					map[string]any{"range": map[string]any{"@timestamp": map[string]any{
+						"gte": from.UTC().Format(time.RFC3339Nano),
+						"lte": to.UTC().Format(time.RFC3339Nano),
+					}}},
+					map[string]any{"match_phrase": map[string]any{"annotation.general.device_name": search.Slab}},
+				},
+			},
+		},
+		"_source": []string{"@timestamp", "log.syslog.message", "annotation.general.device_name"},
+	}
+}
+
+// slabSearchResponse is the part of an Elasticsearch search response the collector reads.
+type slabSearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Source struct {
+				Timestamp time.Time `json:"@timestamp"`
+				Log       struct {
+					Syslog struct {
+						Message string `json:"message"`
+					} `json:"syslog"`
+				} `json:"log"`
+				Annotation struct {
+					General struct {
+						DeviceName string `json:"device_name"`
+					} `json:"general"`
+				} `json:"annotation"`
+			} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// searchSlabLogs queries Elasticsearch for the slab logs between from and to.
+func searchSlabLogs(ctx context.Context, search SlabSearchConfig, from, to time.Time) ([]LogEntry, error) {
+	body, err := json.Marshal(slabQuery(search, from, to))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, search.Timeout)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("%s/%s/_search?ignore_unavailable=true", search.URL, url.PathEscape(search.Index))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if search.Credential != "" {
+		prefix := envPrefix(search.Credential)
+		if key := os.Getenv(prefix + "_ES_API_KEY"); key != "" {
+			req.Header.Set("Authorization", "ApiKey "+key)
+		} else {
+			req.SetBasicAuth(os.Getenv(prefix+"_ES_USER"), os.Getenv(prefix+"_ES_PASS"))
+		}
+	}
+
+	client := &http.Client{}
+	if search.Insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("elasticsearch returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result slabSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid elasticsearch response: %w", err)
+	}
+
+	logs := make([]LogEntry, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		logs = append(logs, LogEntry{
+			Timestamp: hit.Source.Timestamp,
+			Device:    hit.Source.Annotation.General.DeviceName,
+			Message:   hit.Source.Log.Syslog.Message,
+		})
+	}
+
+	return logs, nil
+}
+
+// slabLogsRunCmd runs a slab-logs stage: once the end of the test window has passed, it searches
+// the logs within the window and returns them one message per line, plus the structured entries.
+func slabLogsRunCmd(ctx context.Context, app *App, search SlabSearchConfig, window TestWindow) (string, []LogEntry, error) {
+	if err := window.waitForEnd(ctx, app); err != nil {
+		return "", nil, fmt.Errorf("job stopped by user")
+	}
+
+	app.SetJobActivity(fmt.Sprintf("Searching slab logs of %s (DST %s, %s) on %s", search.Slab, search.Dst, search.Mcast, search.URL))
+
+	logs, err := searchSlabLogs(ctx, search, window.From, window.To)
+	if ctx.Err() == context.Canceled {
+		return "", nil, fmt.Errorf("job stopped by user")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	// finding none is a test outcome for the expect rules, not a failure of the search
+	var output strings.Builder
+	if len(logs) == 0 {
+		output.WriteString(fmt.Sprintf("[NO LOGS] no slab logs found for %s DST %s %s between %s and %s\n",
+			search.Slab, search.Dst, search.Mcast, window.From.Format(time.RFC3339), window.To.Format(time.RFC3339)))
+	}
+	for _, entry := range logs {
+		output.WriteString(entry.Message + "\n")
+	}
+
+	return output.String(), logs, nil
+}
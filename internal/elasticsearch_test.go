@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pythonSlabFilters are the match filters of slab_logs_script.py's query for slab iad1bc-slab001,
+// DST 1 and 239.0.0.1. Its relative range ("now-5m" to "now") is replaced by the test window.
+const pythonSlabFilters = `[
+	{"bool": {"must": [
+		{"multi_match": {"type": "best_fields", "query": "LwrpUpdated", "lenient": true}},
+		{"multi_match": {"type": "phrase", "query": "DST 1", "lenient": true}},
+		{"multi_match": {"type": "phrase", "query": "239.0.0.1", "lenient": true}}
+	]}},
+	{"match_phrase": {"annotation.general.device_name": "iad1bc-slab001"}}
+]`
+
+func testSlabSearch(url string) SlabSearchConfig {
+	search := SlabSearchConfig{URL: url, Slab: "iad1bc-slab001", Dst: "1", Mcast: "239.0.0.1"}
+	resolveSlabSearch(&search)
+	return search
+}
+
+// decodeJSON round-trips v through JSON, so Go maps compare equal to decoded literals.
+func decodeJSON(t *testing.T, v any) any {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSlabQuery(t *testing.T) {
+	from := time.Date(2026, 3, 4, 7, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	to := from.Add(90*time.Second + 500*time.Millisecond)
+
+	query := decodeJSON(t, slabQuery(testSlabSearch("http://127.0.0.1:9200"), from, to)).(map[string]any)
+
+	if query["size"] != float64(10000) {
+		t.Errorf("size = %v, want 10000", query["size"])
+	}
+
+	filters := query["query"].(map[string]any)["bool"].(map[string]any)["filter"].([]any)
+	if len(filters) != 3 {
+		t.Fatalf("filters = %v", filters)
+	}
+
+	var want []any
+	if err := json.Unmarshal([]byte(pythonSlabFilters), &want); err != nil {
+		t.Fatal(err)
+	}
+	if got := []any{filters[0], filters[2]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("match filters = %v, want %v", got, want)
+	}
+
+	// the window is sent in UTC, to the millisecond
+	wantRange := map[string]any{"range": map[string]any{"@timestamp": map[string]any{
+		"gte": "2026-03-04T12:00:00Z",
+		"lte": "2026-03-04T12:01:30.5Z",
+	}}}
+	if !reflect.DeepEqual(filters[1], wantRange) {
+		t.Errorf("range filter = %v, want %v", filters[1], wantRange)
+	}
+
+	// the script only reads the message; the stage also keeps the time and device
+	wantSource := []any{"@timestamp", "log.syslog.message", "annotation.general.device_name"}
+	if !reflect.DeepEqual(query["_source"], wantSource) {
+		t.Errorf("_source = %v, want %v", query["_source"], wantSource)
+	}
+}
+
+func TestSearchSlabLogs(t *testing.T) {
+	var body []byte
+	var path, rawQuery string
+	hits := `{"hits": {"hits": [
+		{"_source": {"@timestamp": "2026-03-04T12:00:01.250Z", "log": {"syslog": {"message": "LwrpUpdated DST 1 239.0.0.1"}},
+			"annotation": {"general": {"device_name": "iad1bc-slab001"}}}}
+	]}}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		path, rawQuery = r.URL.EscapedPath(), r.URL.RawQuery
+		io.WriteString(w, hits)
+	}))
+	defer srv.Close()
+
+	from := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	search := testSlabSearch(srv.URL + "/")
+
+	logs, err := searchSlabLogs(context.Background(), search, from, from.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []LogEntry{{
+		Timestamp: time.Date(2026, 3, 4, 12, 0, 1, 250e6, time.UTC),
+		Device:    "iad1bc-slab001",
+		Message:   "LwrpUpdated DST 1 239.0.0.1",
+	}}
+	if !reflect.DeepEqual(logs, want) {
+		t.Errorf("logs = %+v, want %+v", logs, want)
+	}
+
+	// the index's date math keeps its slashes escaped, like the script's quote(index, safe="")
+	if !strings.HasPrefix(path, "/%3Clog-syslog-error-%7Bnow%2Fd%7D%3E") || !strings.HasSuffix(path, "/_search") {
+		t.Errorf("path = %s", path)
+	}
+	if rawQuery != "ignore_unavailable=true" {
+		t.Errorf("query string = %s", rawQuery)
+	}
+	if !reflect.DeepEqual(decodeJSON(t, json.RawMessage(body)), decodeJSON(t, slabQuery(search, from, from.Add(time.Minute)))) {
+		t.Errorf("request body = %s", body)
+	}
+
+	hits = `{"hits": {"hits": []}}`
+	if logs, err := searchSlabLogs(context.Background(), search, from, from.Add(time.Minute)); err != nil || len(logs) != 0 {
+		t.Errorf("searchSlabLogs() without hits = %v, %v, want no logs and no error", logs, err)
+	}
+}
+
+func TestSlabLogsRunCmdWithoutLogs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"hits": {"hits": []}}`)
+	}))
+	defer srv.Close()
+
+	app, err := NewApp(&AppConfig{}, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	window := TestWindow{From: from, To: from.Add(time.Minute)}
+
+	output, logs, err := slabLogsRunCmd(context.Background(), app, testSlabSearch(srv.URL), window)
+	if err != nil || len(logs) != 0 {
+		t.Fatalf("slabLogsRunCmd() = %v, %v, want no logs and no error", logs, err)
+	}
+	if want := "[NO LOGS] no slab logs found for iad1bc-slab001 DST 1 239.0.0.1 between 2026-03-04T12:00:00Z and 2026-03-04T12:01:00Z\n"; output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+
+	// the rules see no logs, not the note
+	rule := ExpectConfig{Match: "DST 1"}
+	if err := validateExpect(&rule); err != nil {
+		t.Fatal(err)
+	}
+	if got := rule.evaluate(StageResult{Type: StageSlabLogs, Output: output, Logs: logs}); got.Passed || got.Detail != "no matching lines" {
+		t.Errorf("evaluate() = %v (%q), want no matching lines", got.Passed, got.Detail)
+	}
+}
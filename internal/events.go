@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Job event types sent over the job stream
+const (
+	EventOutput   = "output"
+	EventActivity = "activity"
+	EventStep     = "step"
+	EventDone     = "done"
+)
+
+const (
+	eventHistorySize   = 2000 // events replayed to subscribers that join a running job
+	eventSubscriberBuf = 256  // per-subscriber backlog before a slow client is dropped
+)
+
+// JobEvent is a single update published while a job runs.
+type JobEvent struct {
+	Type     string    `json:"type"`
+	JobID    string    `json:"jobId"`
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host,omitempty"`    // host label of an output line
+	Command  int       `json:"command,omitempty"` // 1-based command index of an output line
+	Stream   string    `json:"stream,omitempty"`  // stdout | stderr
+	Line     string    `json:"line,omitempty"`
+	Activity string    `json:"activity,omitempty"`
+	Step     *Step     `json:"step,omitempty"`
+	Error    string    `json:"error,omitempty"` // set on the done event of a failed job
+}
+
+// jobStream holds the recent events of one running job and its subscribers.
+type jobStream struct {
+	history     []JobEvent
+	subscribers map[chan JobEvent]struct{}
+}
+
+// EventHub fans job events out to the clients following a job.
+type EventHub struct {
+	mutex   sync.Mutex
+	streams map[string]*jobStream // job id → stream, only while the job runs
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{streams: map[string]*jobStream{}}
+}
+
+// Open starts a stream for the job id. Opening a stream that is already open is a no-op.
+func (h *EventHub) Open(jobID string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.streams[jobID]; !ok {
+		h.streams[jobID] = &jobStream{subscribers: map[chan JobEvent]struct{}{}}
+	}
+}
+
+// Publish sends ev to every subscriber of its job. Subscribers that cannot keep up are dropped
+// so a slow client never blocks the job.
+func (h *EventHub) Publish(ev JobEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stream, ok := h.streams[ev.JobID]
+	if !ok {
+		return
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	stream.history = append(stream.history, ev)
+	if len(stream.history) > eventHistorySize {
+		stream.history = stream.history[len(stream.history)-eventHistorySize:]
+	}
+
+	for ch := range stream.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the events published so far and a channel for the following ones.
+// The channel is closed when the job finishes. ok is false when the job is not running.
+func (h *EventHub) Subscribe(jobID string) (history []JobEvent, ch chan JobEvent, ok bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stream, ok := h.streams[jobID]
+	if !ok {
+		return nil, nil, false
+	}
+
+	ch = make(chan JobEvent, eventSubscriberBuf)
+	stream.subscribers[ch] = struct{}{}
+
+	return append([]JobEvent(nil), stream.history...), ch, true
+}
+
+// Unsubscribe removes a subscriber that disconnected before the job finished.
+func (h *EventHub) Unsubscribe(jobID string, ch chan JobEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if stream, ok := h.streams[jobID]; ok {
+		if _, has := stream.subscribers[ch]; has {
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close publishes the done event for res and ends its stream.
+func (h *EventHub) Close(res JobResult) {
+	step := res.Step
+	h.Publish(JobEvent{Type: EventDone, JobID: res.ID, Step: &step, Error: res.Error})
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if stream, ok := h.streams[res.ID]; ok {
+		for ch := range stream.subscribers {
+			close(ch)
+		}
+		delete(h.streams, res.ID)
+	}
+}
+
+// lineWriter is an io.Writer that publishes every complete line written to it as an output event.
+type lineWriter struct {
+	hub     *EventHub
+	jobID   string
+	host    string
+	command int
+	stream  string
+	partial []byte
+}
+
+func (app *App) newLineWriter(host string, command int, stream string) *lineWriter {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+
+	return &lineWriter{hub: app.events, jobID: app.jobID, host: host, command: command, stream: stream}
+}
+
+// newOutputBuffer returns the buffer that keeps one output stream of a command attempt of the
+// running job, within the job's output limits.
+func (app *App) newOutputBuffer(host string, command int, stream string) *outputBuffer {
+	return app.currentJobOutput().newBuffer(host, command, stream)
+}
+
+// currentJobOutput returns the output limits and artifacts of the running job.
+func (app *App) currentJobOutput() *jobOutput {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+
+	if app.jobOutput == nil {
+		return newJobOutput(app.Config.File, "")
+	}
+
+	return app.jobOutput
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.publish(string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush publishes a trailing line that did not end with a newline.
+func (w *lineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.publish(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *lineWriter) publish(line string) {
+	w.hub.Publish(JobEvent{
+		Type:    EventOutput,
+		JobID:   w.jobID,
+		Host:    w.host,
+		Command: w.command,
+		Stream:  w.stream,
+		Line:    line,
+	})
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+
+	// nothing is kept for a job without a stream
+	hub.Publish(JobEvent{Type: EventActivity, JobID: "job-1", Activity: "before"})
+	if _, _, ok := hub.Subscribe("job-1"); ok {
+		t.Fatal("Subscribe() to a job that is not running: ok")
+	}
+
+	hub.Open("job-1")
+	hub.Publish(JobEvent{Type: EventActivity, JobID: "job-1", Activity: "Stage 1/2: Scheduler"})
+	hub.Publish(JobEvent{Type: EventActivity, JobID: "job-2", Activity: "other job"})
+
+	history, ch, ok := hub.Subscribe("job-1")
+	if !ok || len(history) != 1 || history[0].Activity != "Stage 1/2: Scheduler" || history[0].Time.IsZero() {
+		t.Fatalf("Subscribe() = %+v, %v", history, ok)
+	}
+
+	hub.Publish(JobEvent{Type: EventOutput, JobID: "job-1", Host: "sdvn", Line: "ok"})
+	if ev := <-ch; ev.Type != EventOutput || ev.Line != "ok" {
+		t.Errorf("live event = %+v", ev)
+	}
+
+	hub.Close(JobResult{ID: "job-1", Step: 1, Error: "exit status 1"})
+	if ev := <-ch; ev.Type != EventDone || ev.Step == nil || *ev.Step != 1 || ev.Error != "exit status 1" {
+		t.Errorf("done event = %+v", ev)
+	}
+	if _, open := <-ch; open {
+		t.Error("the channel is still open after Close()")
+	}
+	if _, _, ok := hub.Subscribe("job-1"); ok {
+		t.Error("Subscribe() after Close(): ok")
+	}
+}
+
+func TestEventHubSlowSubscriber(t *testing.T) {
+	hub := NewEventHub()
+	hub.Open("job-1")
+
+	_, slow, _ := hub.Subscribe("job-1")
+	for i := 0; i <= eventSubscriberBuf; i++ {
+		hub.Publish(JobEvent{Type: EventOutput, JobID: "job-1", Line: fmt.Sprint(i)})
+	}
+
+	// the backlog is delivered, then the channel is closed instead of blocking the job
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != eventSubscriberBuf {
+		t.Errorf("slow subscriber received %d events, want %d", received, eventSubscriberBuf)
+	}
+
+	// later subscribers still get the history
+	if history, _, _ := hub.Subscribe("job-1"); len(history) != eventSubscriberBuf+1 {
+		t.Errorf("history = %d events, want %d", len(history), eventSubscriberBuf+1)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	hub := NewEventHub()
+	hub.Open("job-1")
+	_, ch, _ := hub.Subscribe("job-1")
+
+	w := &lineWriter{hub: hub, jobID: "job-1", host: "scheduler", command: 2, stream: "stdout"}
+	w.Write([]byte("first\r\nsec"))
+	w.Write([]byte("ond\nthi"))
+	w.Flush()
+	w.Flush()
+
+	var lines []string
+	for len(ch) > 0 {
+		ev := <-ch
+		if ev.Host != "scheduler" || ev.Command != 2 || ev.Stream != "stdout" {
+			t.Errorf("event = %+v", ev)
+		}
+		lines = append(lines, ev.Line)
+	}
+	if want := "[first second thi]"; fmt.Sprint(lines) != want {
+		t.Errorf("lines = %v, want %v", lines, want)
+	}
+}
+
+// readSSE reads the events of an SSE response until it ends.
+func readSSE(t *testing.T, resp *http.Response) []JobEvent {
+	t.Helper()
+
+	var events []JobEvent
+	var name string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var ev JobEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatal(err)
+			}
+			if ev.Type != name {
+				t.Errorf("event %q carries type %q", name, ev.Type)
+			}
+			events = append(events, ev)
+		}
+	}
+
+	return events
+}
+
+func TestStreamJobEvents(t *testing.T) {
+	app, err := NewApp(&AppConfig{}, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chi.NewRouter()
+	RegisterJobHandlers(r, app)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	end := time.Date(2026, 3, 4, 12, 1, 0, 0, time.UTC)
+	app.Store.SaveResult(JobResult{ID: "job-0", EndTime: end, Step: 2, Error: "exit status 1"})
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+		want   []string // event types
+	}{
+		{name: "finished job", id: "job-0", status: http.StatusOK, want: []string{EventDone}},
+		{name: "unknown job", id: "missing", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/api/jobs/" + tt.id + "/stream")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			events := readSSE(t, resp)
+			var types []string
+			for _, ev := range events {
+				types = append(types, ev.Type)
+			}
+			if fmt.Sprint(types) != fmt.Sprint(tt.want) {
+				t.Fatalf("events = %v, want %v", types, tt.want)
+			}
+			if done := events[0]; !done.Time.Equal(end) || done.Step == nil || *done.Step != 2 || done.Error != "exit status 1" {
+				t.Errorf("done event = %+v", done)
+			}
+		})
+	}
+
+	t.Run("running job", func(t *testing.T) {
+		app.events.Open("job-1")
+		app.events.Publish(JobEvent{Type: EventActivity, JobID: "job-1", Activity: "Stage 1/1: Scheduler"})
+
+		resp, err := http.Get(srv.URL + "/api/jobs/job-1/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+
+		// the response starts once the client is subscribed: what follows arrives live
+		app.events.Publish(JobEvent{Type: EventOutput, JobID: "job-1", Host: "scheduler", Command: 1, Stream: "stdout", Line: "take ok"})
+		app.events.Close(JobResult{ID: "job-1"})
+
+		events := readSSE(t, resp)
+		if len(events) != 3 || events[0].Type != EventActivity || events[1].Line != "take ok" || events[2].Type != EventDone || events[2].Error != "" {
+			t.Errorf("events = %+v", events)
+		}
+	})
+}
@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Job verdicts
+const (
+	VerdictPass = "PASS"
+	VerdictFail = "FAIL"
+)
+
+// ExpectConfig is one assertion on the outcome of a stage. Match and NotMatch are regular
+// expressions (and command templates, whose params are inserted as literal text) tested against
+// each output line: stdout and stderr of the commands, the messages found by a slab-logs stage or
+// the captured output of a background stage.
+//
+//	expect:
+//	  - match: 'LwrpUpdated.*DST {{.Dst}}\b' # at least one matching line
+//	    min: 1
+//	    max: 4
+//	  - not_match: "Traceback|ERROR"
+//	  - exit_code: 0                           # of every command
+type ExpectConfig struct {
+	Name     string `mapstructure:"name"` // shown in the result, defaults to a description of the rule
+	Match    string `mapstructure:"match"`
+	NotMatch string `mapstructure:"not_match"`
+	Min      *int   `mapstructure:"min"` // minimum matching lines, defaults to 1 when max is not set
+	Max      *int   `mapstructure:"max"`
+	ExitCode *int   `mapstructure:"exit_code"`
+
+	match    *regexp.Regexp
+	notMatch *regexp.Regexp
+}
+
+// AssertionResult is the verdict of one expect rule.
+type AssertionResult struct {
+	Stage  string
+	Name   string
+	Passed bool
+	Detail string // what was found, e.g. "3 matching lines"
+}
+
+// String formats the assertion for reports, e.g. "[FAIL] Slab: match "LwrpUpdated" - no matching lines".
+func (a AssertionResult) String() string {
+	status := VerdictPass
+	if !a.Passed {
+		status = VerdictFail
+	}
+
+	if a.Detail == "" {
+		return fmt.Sprintf("[%s] %s: %s", status, a.Stage, a.Name)
+	}
+	return fmt.Sprintf("[%s] %s: %s - %s", status, a.Stage, a.Name, a.Detail)
+}
+
+// validateExpect checks an expect rule. Patterns without template actions are compiled here so
+// mistakes show up when the config loads.
+func validateExpect(rule *ExpectConfig) error {
+	if rule.Match == "" && rule.NotMatch == "" && rule.ExitCode == nil {
+		return fmt.Errorf("expect: no match, not_match or exit_code")
+	}
+	if rule.Match != "" && rule.NotMatch != "" {
+		return fmt.Errorf("expect: match and not_match in one rule")
+	}
+	if (rule.Min != nil || rule.Max != nil) && rule.Match == "" {
+		return fmt.Errorf("expect: min and max need a match")
+	}
+	if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+		return fmt.Errorf("expect: min is greater than max")
+	}
+
+	if rule.Name == "" {
+		rule.Name = rule.describe()
+	}
+
+	if !strings.Contains(rule.Match+rule.NotMatch, "{{") {
+		return rule.compile()
+	}
+
+	return nil
+}
+
+// compile compiles the (rendered) patterns of the rule.
+func (rule *ExpectConfig) compile() error {
+	var err error
+
+	if rule.Match != "" {
+		if rule.match, err = regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("expect: invalid match: %w", err)
+		}
+	}
+	if rule.NotMatch != "" {
+		if rule.notMatch, err = regexp.Compile(rule.NotMatch); err != nil {
+			return fmt.Errorf("expect: invalid not_match: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// renderExpect returns a copy of rules with the templated patterns rendered from params and
+// compiled. String params are inserted as literal text, so a multicast group's dots only match
+// dots.
+func renderExpect(rules []ExpectConfig, params map[string]any) ([]ExpectConfig, error) {
+	rendered := make([]ExpectConfig, len(rules))
+
+	quoted := make(map[string]any, len(params))
+	for key, value := range params {
+		if s, ok := value.(string); ok {
+			value = regexp.QuoteMeta(s)
+		}
+		quoted[key] = value
+	}
+	params = quoted
+
+	for i, rule := range rules {
+		if strings.Contains(rule.Match+rule.NotMatch, "{{") {
+			var err error
+			if rule.Match, err = renderCommand(rule.Match, params); err != nil {
+				return nil, fmt.Errorf("expect %q: %w", rule.Name, err)
+			}
+			if rule.NotMatch, err = renderCommand(rule.NotMatch, params); err != nil {
+				return nil, fmt.Errorf("expect %q: %w", rule.Name, err)
+			}
+			if err := rule.compile(); err != nil {
+				return nil, err
+			}
+		}
+
+		rendered[i] = rule
+	}
+
+	return rendered, nil
+}
+
+// describe returns a readable form of the rule, e.g. `match "LwrpUpdated" (min 1, max 4)`.
+func (rule ExpectConfig) describe() string {
+	var parts []string
+
+	switch {
+	case rule.Match != "":
+		var limits []string
+		if rule.Min != nil {
+			limits = append(limits, fmt.Sprintf("min %d", *rule.Min))
+		}
+		if rule.Max != nil {
+			limits = append(limits, fmt.Sprintf("max %d", *rule.Max))
+		}
+
+		desc := fmt.Sprintf("match %q", rule.Match)
+		if len(limits) > 0 {
+			desc += " (" + strings.Join(limits, ", ") + ")"
+		}
+		parts = append(parts, desc)
+
+	case rule.NotMatch != "":
+		parts = append(parts, fmt.Sprintf("not_match %q", rule.NotMatch))
+	}
+
+	if rule.ExitCode != nil {
+		parts = append(parts, fmt.Sprintf("exit_code %d", *rule.ExitCode))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// evaluate checks the rule against the stage's result.
+func (rule ExpectConfig) evaluate(stage StageResult) AssertionResult {
+	res := AssertionResult{Stage: stage.Name, Name: rule.Name, Passed: true}
+	var details []string
+
+	fail := func(format string, args ...any) {
+		res.Passed = false
+		details = append(details, fmt.Sprintf(format, args...))
+	}
+
+	if rule.match != nil || rule.notMatch != nil {
+		lines, truncated := stageLines(stage)
+
+		count := 0
+		for _, line := range lines {
+			if (rule.match != nil && rule.match.MatchString(line)) || (rule.notMatch != nil && rule.notMatch.MatchString(line)) {
+				count++
+			}
+		}
+
+		switch {
+		case rule.notMatch != nil && count > 0:
+			fail("%d matching lines, expected none", count)
+		case rule.notMatch != nil:
+			details = append(details, "no matching lines")
+		case rule.Min != nil && count < *rule.Min:
+			fail("%d matching lines, expected at least %d", count, *rule.Min)
+		case rule.Max != nil && count > *rule.Max:
+			fail("%d matching lines, expected at most %d", count, *rule.Max)
+		case rule.Min == nil && rule.Max == nil && count == 0:
+			fail("no matching lines")
+		default:
+			details = append(details, fmt.Sprintf("%d matching lines", count))
+		}
+
+		if truncated {
+			details = append(details, "output truncated, only the kept lines were checked")
+		}
+	}
+
+	if rule.ExitCode != nil {
+		exits := lastAttempts(stage.Results)
+		if len(exits) == 0 {
+			fail("no command ran")
+		}
+
+		for _, r := range exits {
+			if r.ExitStatus != *rule.ExitCode {
+				fail("%q exited with %d, expected %d", r.Command, r.ExitStatus, *rule.ExitCode)
+			}
+		}
+		if res.Passed && len(exits) > 0 {
+			details = append(details, fmt.Sprintf("%d commands exited with %d", len(exits), *rule.ExitCode))
+		}
+	}
+
+	res.Detail = strings.Join(details, "; ")
+
+	return res
+}
+
+// notRun is the verdict of a rule whose stage did not run to the end.
+func (rule ExpectConfig) notRun(stage StageResult) AssertionResult {
+	return AssertionResult{Stage: stage.Name, Name: rule.Name, Detail: "stage " + stage.Status}
+}
+
+// stageLines returns the output lines the rules of a stage are matched against, and whether output
+// over the capture limits was dropped from them.
+func stageLines(stage StageResult) ([]string, bool) {
+	var lines []string
+	add := func(text string) {
+		if text = strings.TrimRight(text, "\n"); text != "" {
+			lines = append(lines, strings.Split(text, "\n")...)
+		}
+	}
+	truncated := false
+
+	switch {
+	case len(stage.Results) > 0:
+		// the output of a retried command is that of its final attempt
+		for _, r := range lastAttempts(stage.Results) {
+			add(r.Stdout)
+			add(r.Stderr)
+			truncated = truncated || r.Truncated
+		}
+	case stage.Type == StageSlabLogs || len(stage.Logs) > 0:
+		for _, entry := range stage.Logs {
+			add(entry.Message)
+		}
+	default:
+		// captured background output ends with a marker when lines were dropped
+		add(stage.Output)
+		truncated = strings.Contains(stage.Output, "\n[TRUNCATED] ") || strings.HasPrefix(stage.Output, "[TRUNCATED] ")
+	}
+
+	return lines, truncated
+}
+
+// lastAttempts returns the final attempt of each command.
+func lastAttempts(results []CommandResult) []CommandResult {
+	var last []CommandResult
+
+	for i, r := range results {
+		if i+1 == len(results) || results[i+1].Attempt == 1 {
+			last = append(last, r)
+		}
+	}
+
+	return last
+}
+
+// evaluateExpect records the verdicts of the stage's rules in the job result.
+func (run *pipelineRun) evaluateExpect(i int, rules []ExpectConfig) {
+	stage := run.result.Stages[i]
+
+	for _, rule := range rules {
+		if stage.Status == StageSucceeded || stage.Status == StageFailed {
+			run.result.Assertions = append(run.result.Assertions, rule.evaluate(stage))
+		} else {
+			run.result.Assertions = append(run.result.Assertions, rule.notRun(stage))
+		}
+	}
+}
+
+// verdict returns PASS when every assertion passed, FAIL when one did not, and "" without
+// assertions.
+func verdict(assertions []AssertionResult) string {
+	if len(assertions) == 0 {
+		return ""
+	}
+
+	for _, a := range assertions {
+		if !a.Passed {
+			return VerdictFail
+		}
+	}
+
+	return VerdictPass
+}
+
+// verdictNote describes the verdict for the job activity, e.g. ": FAIL (1 of 3 assertions failed)".
+func verdictNote(assertions []AssertionResult) string {
+	failed := 0
+	for _, a := range assertions {
+		if !a.Passed {
+			failed++
+		}
+	}
+
+	switch {
+	case len(assertions) == 0:
+		return ""
+	case failed > 0:
+		return fmt.Sprintf(": %s (%d of %d assertions failed)", VerdictFail, failed, len(assertions))
+	default:
+		return fmt.Sprintf(": %s (%d assertions)", VerdictPass, len(assertions))
+	}
+}
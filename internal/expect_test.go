@@ -0,0 +1,182 @@
+package internal
+
+import "testing"
+
+func intPtr(n int) *int { return &n }
+
+func TestRenderExpect(t *testing.T) {
+	rules := []ExpectConfig{
+		{Match: `LwrpUpdated.*DST {{.Dst}}\b.*{{.Mcast}}`},
+		{NotMatch: "Traceback|ERROR"},
+	}
+	for i := range rules {
+		if err := validateExpect(&rules[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rendered, err := renderExpect(rules, map[string]any{"Dst": "1", "Mcast": "239.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"LwrpUpdated SRC 4 DST 1 ADDR 239.0.0.1", true},
+		{"LwrpUpdated SRC 4 DST 10 ADDR 239.0.0.1", false}, // \b after the param
+		{"LwrpUpdated SRC 4 DST 1 ADDR 239x0x0x1", false},  // the dots are literal
+	}
+	for _, tt := range tests {
+		if got := rendered[0].match.MatchString(tt.line); got != tt.want {
+			t.Errorf("%s matches %q = %v, want %v", rendered[0].Match, tt.line, got, tt.want)
+		}
+	}
+
+	// rules without placeholders are compiled when the config loads and left alone
+	if rendered[1].notMatch != rules[1].notMatch {
+		t.Error("untemplated rule was compiled again")
+	}
+	if rules[0].match != nil {
+		t.Error("renderExpect() changed the configured rule")
+	}
+
+	if _, err := renderExpect(rules, map[string]any{"Dst": "1"}); err == nil {
+		t.Error("renderExpect() without Mcast: want an error")
+	}
+}
+
+func TestExpectEvaluate(t *testing.T) {
+	results := []CommandResult{
+		{Command: "take.sh", Attempt: 1, ExitStatus: 1, Stdout: "LwrpUpdated DST 1\n"},
+		{Command: "take.sh", Attempt: 2, ExitStatus: 0, Stdout: "LwrpUpdated DST 1\nLwrpUpdated DST 1\n"},
+		{Command: "check.sh", Attempt: 1, ExitStatus: 0, Stderr: "warning: slow\n"},
+	}
+
+	tests := []struct {
+		name       string
+		rule       ExpectConfig
+		stage      StageResult
+		wantPassed bool
+		wantDetail string
+	}{
+		{
+			name:       "match counts the lines of the final attempts",
+			rule:       ExpectConfig{Match: "LwrpUpdated"},
+			stage:      StageResult{Results: results},
+			wantPassed: true,
+			wantDetail: "2 matching lines",
+		},
+		{
+			name:       "match over max",
+			rule:       ExpectConfig{Match: "LwrpUpdated", Max: intPtr(1)},
+			stage:      StageResult{Results: results},
+			wantDetail: "2 matching lines, expected at most 1",
+		},
+		{
+			name:       "match under min",
+			rule:       ExpectConfig{Match: "LwrpUpdated", Min: intPtr(4)},
+			stage:      StageResult{Results: results},
+			wantDetail: "2 matching lines, expected at least 4",
+		},
+		{
+			name: "not_match ignores a failed attempt that was retried",
+			rule: ExpectConfig{NotMatch: "Traceback"},
+			stage: StageResult{Results: []CommandResult{
+				{Command: "take.sh", Attempt: 1, ExitStatus: 1, Stderr: "Traceback (most recent call last):\n"},
+				{Command: "take.sh", Attempt: 2, ExitStatus: 0, Stdout: "LwrpUpdated DST 1\n"},
+			}},
+			wantPassed: true,
+			wantDetail: "no matching lines",
+		},
+		{
+			name:       "empty lines are not output",
+			rule:       ExpectConfig{Match: "^$", Max: intPtr(0)},
+			stage:      StageResult{Results: results},
+			wantPassed: true,
+			wantDetail: "0 matching lines",
+		},
+		{
+			name:       "no match",
+			rule:       ExpectConfig{Match: "DST 2"},
+			stage:      StageResult{Results: results},
+			wantDetail: "no matching lines",
+		},
+		{
+			name:       "not_match on stderr",
+			rule:       ExpectConfig{NotMatch: "warning"},
+			stage:      StageResult{Results: results},
+			wantDetail: "1 matching lines, expected none",
+		},
+		{
+			name:       "exit_code checks the final attempts",
+			rule:       ExpectConfig{ExitCode: intPtr(0)},
+			stage:      StageResult{Results: results},
+			wantPassed: true,
+			wantDetail: "2 commands exited with 0",
+		},
+		{
+			name:       "exit_code without commands",
+			rule:       ExpectConfig{ExitCode: intPtr(0)},
+			stage:      StageResult{},
+			wantDetail: "no command ran",
+		},
+		{
+			name:       "slab logs",
+			rule:       ExpectConfig{Match: "LwrpUpdated"},
+			stage:      StageResult{Logs: []LogEntry{{Message: "LwrpUpdated DST 1"}}, Output: "ignored LwrpUpdated\n"},
+			wantPassed: true,
+			wantDetail: "1 matching lines",
+		},
+		{
+			name:       "truncated command output",
+			rule:       ExpectConfig{NotMatch: "Traceback"},
+			stage:      StageResult{Results: []CommandResult{{Stdout: "head\n[TRUNCATED] 10 bytes omitted\ntail\n", Truncated: true}}},
+			wantPassed: true,
+			wantDetail: "no matching lines; output truncated, only the kept lines were checked",
+		},
+		{
+			name:       "truncated capture",
+			rule:       ExpectConfig{Match: "LwrpUpdated"},
+			stage:      StageResult{Output: "LwrpUpdated DST 1\n[TRUNCATED] 5 more lines (300 bytes) not captured\n"},
+			wantPassed: true,
+			wantDetail: "1 matching lines; output truncated, only the kept lines were checked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			if err := validateExpect(&rule); err != nil {
+				t.Fatal(err)
+			}
+
+			got := rule.evaluate(tt.stage)
+			if got.Passed != tt.wantPassed || got.Detail != tt.wantDetail {
+				t.Errorf("evaluate() = %v (%q), want %v (%q)", got.Passed, got.Detail, tt.wantPassed, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	pass := AssertionResult{Stage: "Slab", Name: "route switched", Passed: true, Detail: "1 matching lines"}
+	fail := ExpectConfig{Name: "exit_code 0"}.notRun(StageResult{Name: "SDVN", Status: StageSkipped})
+
+	if got := verdict(nil); got != "" {
+		t.Errorf("verdict(nil) = %q", got)
+	}
+	if got := verdict([]AssertionResult{pass}); got != VerdictPass {
+		t.Errorf("verdict(pass) = %q", got)
+	}
+	if got := verdict([]AssertionResult{pass, fail}); got != VerdictFail {
+		t.Errorf("verdict(pass, fail) = %q", got)
+	}
+	if got := verdictNote([]AssertionResult{pass, fail}); got != ": FAIL (1 of 2 assertions failed)" {
+		t.Errorf("verdictNote() = %q", got)
+	}
+	if got := fail.String(); got != "[FAIL] SDVN: exit_code 0 - stage skipped" {
+		t.Errorf("String() = %q", got)
+	}
+}
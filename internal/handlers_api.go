@@ -3,8 +3,14 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -38,8 +44,36 @@ func WriteJSON(w http.ResponseWriter, status int, data interface{}, headers ...h
 
 func RegisterJobHandlers(r chi.Router, app *App) {
 	r.Post("/api/runjob", func(w http.ResponseWriter, r *http.Request) {
+		// the body is optional: {"profile": "<name>", "params": {"<name>": "<value>"}}
+		var body struct {
+			Profile string            `json:"profile"`
+			Params  map[string]string `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "bad request"})
+			return
+		}
+
+		profile, err := app.Profile(body.Profile)
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		req := RunRequest{
+			Trigger: fmt.Sprintf("manual (%s)", r.RemoteAddr),
+			Profile: profile.Name,
+			Params:  body.Params,
+		}
+
+		// unknown or missing template variables fail here, before any SSH connection
+		if err := app.ValidateRunRequest(req); err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
 		ctx := context.Background()
-		result := app.RunJob(ctx)
+		result := app.RunJob(ctx, req)
 
 		WriteJSON(w, http.StatusAccepted, result)
 	})
@@ -61,62 +95,279 @@ func RegisterJobHandlers(r chi.Router, app *App) {
 		WriteJSON(w, http.StatusOK, res)
 	})
 
+	r.Get("/api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		filter, err := ParseJobFilter(r)
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		results, next, err := app.Store.ListResults(filter)
+		if err == ErrInvalidCursor {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		jobs := make([]JobSummary, 0, len(results))
+		for _, res := range results {
+			jobs = append(jobs, NewJobSummary(res))
+		}
+
+		WriteJSON(w, http.StatusOK, map[string]any{"jobs": jobs, "nextCursor": next})
+	})
+
+	r.Get("/api/trends/latency", func(w http.ResponseWriter, r *http.Request) {
+		filter, err := ParseJobFilter(r)
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// the trend covers every matching run, not one page
+		results, total, err := app.latencyResults(filter)
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		runs, segments := latencyTrend(results)
+
+		WriteJSON(w, http.StatusOK, map[string]any{"runs": runs, "segments": segments, "runsWithoutLatency": total - len(runs)})
+	})
+
+	r.Get("/api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		res, err := app.Store.GetResult(chi.URLParam(r, "id"))
+		if err == ErrNotFound {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+			return
+		}
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, res)
+	})
+
+	r.Get("/api/jobs/{id}/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		res, err := app.Store.GetResult(chi.URLParam(r, "id"))
+		if err == ErrNotFound {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+			return
+		}
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, map[string]any{"artifacts": app.jobArtifacts(res)})
+	})
+
+	r.Get("/api/jobs/{id}/timeline", func(w http.ResponseWriter, r *http.Request) {
+		res, err := app.Store.GetResult(chi.URLParam(r, "id"))
+		if err == ErrNotFound {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+			return
+		}
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		events := res.Timeline
+		if events == nil {
+			events = []RouteEvent{}
+		}
+
+		WriteJSON(w, http.StatusOK, map[string]any{"id": res.ID, "events": events})
+	})
+
+	r.Get("/api/jobs/{id}/artifacts.zip", func(w http.ResponseWriter, r *http.Request) {
+		res, err := app.Store.GetResult(chi.URLParam(r, "id"))
+		if err == ErrNotFound {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+			return
+		}
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "artifacts-"+res.ID+".zip"))
+
+		// the headers are sent with the first entry, so a failure can only be logged
+		if err := app.writeArtifactsZip(w, res.ID, app.jobArtifacts(res)); err != nil {
+			slog.Error("failed to write artifacts zip", "id", res.ID, "error", err)
+		}
+	})
+
+	r.Get("/api/jobs/{id}/artifacts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		id, name := chi.URLParam(r, "id"), chi.URLParam(r, "name")
+
+		// both parts must be plain file names inside the artifact directory
+		if id != filepath.Base(id) || name != filepath.Base(name) || strings.HasPrefix(id, ".") || strings.HasPrefix(name, ".") {
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid artifact"})
+			return
+		}
+
+		path := filepath.Join(app.Config.File.Artifacts.Dir, id, name)
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "artifact not found"})
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		http.ServeFile(w, r, path)
+	})
+
+	r.Get("/api/jobs/{id}/stream", func(w http.ResponseWriter, r *http.Request) {
+		StreamJobEvents(w, r, app, chi.URLParam(r, "id"))
+	})
+
 	r.Get("/api/jobstatus", func(w http.ResponseWriter, r *http.Request) {
 		app.mutex.Lock()
 		running := app.running
+		jobID := app.jobID
 		activity := app.jobActivity
 		step := app.step
 		app.mutex.Unlock()
 
 		WriteJSON(w, http.StatusOK, map[string]any{
 			"running":  running,
+			"id":       jobID,
 			"activity": activity,
 			"step":     step,
 		})
 	})
 
+	r.Get("/api/pipeline", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]any{
+			"stages":  app.Config.File.Pipeline,
+			"finally": app.Config.File.Finally,
+		})
+	})
+
+	r.Get("/api/profiles", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]any{
+			"profiles":       app.Profiles(),
+			"defaultProfile": app.Config.File.DefaultProfile,
+		})
+	})
+
 	r.Get("/api/version", func(w http.ResponseWriter, r *http.Request) {
 		WriteJSON(w, http.StatusOK, map[string]string{"version": AppVersion})
 	})
 }
 
+const (
+	defaultNextRuns = 5  // fire times returned per schedule unless ?next= is given
+	maxNextRuns     = 50 // upper bound for ?next=
+)
+
+// scheduleRequest is the POST/PUT body of the schedules API: either a one-time "time" (RFC3339)
+// or a "recurrence" (cron expression or daily/weekly pattern), and the profile and params to run.
+type scheduleRequest struct {
+	Time       string            `json:"time"`
+	Recurrence *Recurrence       `json:"recurrence"`
+	Profile    string            `json:"profile"`
+	Params     map[string]string `json:"params"`
+}
+
+// Schedule validates the request and converts it into an unregistered Schedule.
+func (req scheduleRequest) Schedule(app *App) (*Schedule, error) {
+	profile, err := app.Profile(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.ValidateRunRequest(RunRequest{Profile: profile.Name, Params: req.Params}); err != nil {
+		return nil, err
+	}
+
+	sched, err := req.schedule()
+	if err != nil {
+		return nil, err
+	}
+	sched.Profile = profile.Name
+	sched.Params = req.Params
+
+	return sched, nil
+}
+
+func (req scheduleRequest) schedule() (*Schedule, error) {
+	if req.Recurrence != nil {
+		if err := req.Recurrence.Validate(); err != nil {
+			return nil, err
+		}
+
+		sched := &Schedule{Recurrence: req.Recurrence}
+		if next := sched.Upcoming(1); len(next) > 0 {
+			sched.Time = next[0]
+		}
+
+		return sched, nil
+	}
+
+	schedTime, err := time.Parse(time.RFC3339, req.Time)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time")
+	}
+
+	return &Schedule{Time: schedTime}, nil
+}
+
 func RegisterSchedulerHandlers(r chi.Router, app *App) {
 	r.Get("/api/schedules", func(w http.ResponseWriter, r *http.Request) {
+		next := defaultNextRuns
+		if v := r.URL.Query().Get("next"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid next"})
+				return
+			}
+			next = min(n, maxNextRuns)
+		}
+
 		app.scheduleMutex.Lock()
 		defer app.scheduleMutex.Unlock()
 
-		var list []*Schedule
+		var list []Schedule
 		for _, sched := range app.schedules {
-			list = append(list, sched)
+			view := *sched
+			view.NextRuns = sched.Upcoming(next)
+			list = append(list, view)
 		}
 
 		WriteJSON(w, http.StatusOK, map[string]any{"schedules": list})
 	})
 
 	r.Post("/api/schedules", func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			Time string `json:"time"`
-		}
+		var req scheduleRequest
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
 
-		schedTime, err := time.Parse(time.RFC3339, req.Time)
+		sched, err := req.Schedule(app)
 		if err != nil {
-			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid time"})
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
 
 		// Conflict check
-		if conflict := app.CheckScheduleConflict(schedTime, ""); conflict != "" {
+		if conflict := app.CheckScheduleConflict(sched, ""); conflict != "" {
 			WriteJSON(w, http.StatusConflict, map[string]string{"error": conflict})
 			return
 		}
 
 		id := uuid.New().String()
-		sched := &Schedule{ID: id, Time: schedTime}
+		sched.ID = id
 
 		if err := app.AddScheduledJob(sched); err != nil {
 			slog.Error("failed to create cron task", "error", err)
@@ -126,31 +377,33 @@ func RegisterSchedulerHandlers(r chi.Router, app *App) {
 
 		app.scheduleMutex.Lock()
 		app.schedules[id] = sched
+		app.SaveSchedule(sched)
 		app.scheduleMutex.Unlock()
 
-		WriteJSON(w, http.StatusCreated, sched)
+		view := *sched
+		view.NextRuns = sched.Upcoming(defaultNextRuns)
+
+		WriteJSON(w, http.StatusCreated, view)
 	})
 
 	r.Put("/api/schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
 		scheduleID := chi.URLParam(r, "id")
 
-		var req struct {
-			Time string `json:"time"`
-		}
+		var req scheduleRequest
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
 
-		schedTime, err := time.Parse(time.RFC3339, req.Time)
+		update, err := req.Schedule(app)
 		if err != nil {
-			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid time"})
+			WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
 
 		// Conflict, but allow for updating THIS schedule
-		if conflict := app.CheckScheduleConflict(schedTime, scheduleID); conflict != "" {
+		if conflict := app.CheckScheduleConflict(update, scheduleID); conflict != "" {
 			WriteJSON(w, http.StatusConflict, map[string]string{"error": conflict})
 			return
 		}
@@ -164,7 +417,6 @@ func RegisterSchedulerHandlers(r chi.Router, app *App) {
 
 			sched, ok = app.schedules[scheduleID]
 			if !ok {
-				http.Error(w, "not found", http.StatusNotFound)
 				return
 			}
 
@@ -174,7 +426,15 @@ func RegisterSchedulerHandlers(r chi.Router, app *App) {
 			}
 		}()
 
-		sched.Time = schedTime
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		sched.Time = update.Time
+		sched.Recurrence = update.Recurrence
+		sched.Profile = update.Profile
+		sched.Params = update.Params
 
 		if err := app.AddScheduledJob(sched); err != nil {
 			slog.Error("failed to create cron task", "error", err)
@@ -182,7 +442,14 @@ func RegisterSchedulerHandlers(r chi.Router, app *App) {
 			return
 		}
 
-		WriteJSON(w, http.StatusCreated, sched)
+		app.scheduleMutex.Lock()
+		app.SaveSchedule(sched)
+		view := *sched
+		app.scheduleMutex.Unlock()
+
+		view.NextRuns = sched.Upcoming(defaultNextRuns)
+
+		WriteJSON(w, http.StatusCreated, view)
 	})
 
 	r.Delete("/api/schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
@@ -193,27 +460,31 @@ func RegisterSchedulerHandlers(r chi.Router, app *App) {
 
 		delete(app.schedules, id)
 
+		if err := app.Store.DeleteSchedule(id); err != nil {
+			slog.Error("failed to delete stored schedule", "id", id, "error", err)
+		}
+
 		if job, ok := app.scheduleJobs[id]; ok {
 			app.scheduler.RemoveJob(job.ID())
 			delete(app.scheduleJobs, id)
 		}
 
-		delete(app.scheduleResults, id)
-
 		w.WriteHeader(http.StatusNoContent)
 	})
 
 	r.Get("/api/schedules/{id}/result", func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 
-		app.scheduleMutex.Lock()
-		defer app.scheduleMutex.Unlock()
-
-		result, ok := app.scheduleResults[id]
-		if !ok {
-			result = &ScheduleResult{Output: ""} // empty if not found
+		res, err := app.Store.LatestScheduleResult(id)
+		if err == ErrNotFound {
+			WriteJSON(w, http.StatusOK, &ScheduleResult{Output: ""}) // empty if not found
+			return
+		}
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
 		}
 
-		WriteJSON(w, http.StatusOK, result)
+		WriteJSON(w, http.StatusOK, NewScheduleResult(res))
 	})
 }
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const streamKeepalive = 15 * time.Second
+
+// StreamJobEvents serves the events of a job as Server-Sent Events. Clients following a running job
+// first receive the events published so far, then live output lines, activity and step changes,
+// and finally a "done" event. For a finished job only the "done" event is sent.
+func StreamJobEvents(w http.ResponseWriter, r *http.Request, app *App, jobID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	history, ch, running := app.events.Subscribe(jobID)
+	if !running {
+		res, err := app.Store.GetResult(jobID)
+		if err != nil {
+			WriteJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+			return
+		}
+
+		step := res.Step
+		history = []JobEvent{{Type: EventDone, JobID: res.ID, Time: res.EndTime, Step: &step, Error: res.Error}}
+	} else {
+		defer app.events.Unsubscribe(jobID, ch)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, ev := range history {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	if !running {
+		return
+	}
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case ev, open := <-ch:
+			if !open {
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes ev in the SSE wire format, using the event type as the SSE event name.
+func writeEvent(w http.ResponseWriter, ev JobEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+
+	return err
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a history cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// JobFilter narrows a job history listing. Zero values match everything.
+type JobFilter struct {
+	RunType    *RunType
+	Profile    string
+	Success    *bool
+	Verdict    string // PASS or FAIL
+	FailedStep *Step
+	From       time.Time // inclusive lower bound on StartTime
+	To         time.Time // exclusive upper bound on StartTime
+	Cursor     string    // opaque cursor returned by the previous page
+	Limit      int
+}
+
+// Match reports whether res passes every filter that is set.
+func (f JobFilter) Match(res JobResult) bool {
+	if f.RunType != nil && res.RunType != *f.RunType {
+		return false
+	}
+	if f.Profile != "" && !strings.EqualFold(res.Profile, f.Profile) {
+		return false
+	}
+	if f.Success != nil && (res.Error == "") != *f.Success {
+		return false
+	}
+	if f.Verdict != "" && res.Verdict != f.Verdict {
+		return false
+	}
+	if step, ok := failedStep(res); f.FailedStep != nil && (!ok || step != *f.FailedStep) {
+		return false
+	}
+	if !f.From.IsZero() && res.StartTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !res.StartTime.Before(f.To) {
+		return false
+	}
+
+	return true
+}
+
+// JobSummary is the condensed form of a JobResult returned by the history listing.
+type JobSummary struct {
+	ID         string    `json:"id"`
+	RunType    RunType   `json:"runType"`
+	Trigger    string    `json:"trigger"`
+	Profile    string    `json:"profile,omitempty"`
+	ScheduleID string    `json:"scheduleId,omitempty"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	Duration   string    `json:"duration"`
+	Success    bool      `json:"success"`
+	Verdict    string    `json:"verdict,omitempty"`
+	FailedStep *Step     `json:"failedStep,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func NewJobSummary(res JobResult) JobSummary {
+	summary := JobSummary{
+		ID:         res.ID,
+		RunType:    res.RunType,
+		Trigger:    res.Trigger,
+		Profile:    res.Profile,
+		ScheduleID: res.ScheduleID,
+		StartTime:  res.StartTime,
+		EndTime:    res.EndTime,
+		Duration:   res.EndTime.Sub(res.StartTime).Round(time.Millisecond).String(),
+		Success:    res.Error == "",
+		Verdict:    res.Verdict,
+		Error:      res.Error,
+	}
+
+	if step, ok := failedStep(res); ok {
+		summary.FailedStep = &step
+	}
+
+	return summary
+}
+
+// failedStep returns the stage a run failed or was stopped in; false when it succeeded or ended
+// before a stage ran (e.g. an unknown profile, or a skipped or missed schedule). Results stored
+// before stages had a status are taken at their Step.
+func failedStep(res JobResult) (Step, bool) {
+	if res.Error == "" || int(res.Step) < 0 || int(res.Step) >= len(res.Stages) {
+		return 0, false
+	}
+
+	switch res.Stages[res.Step].Status {
+	case StageFailed, StageCanceled, "":
+		return res.Step, true
+	}
+
+	return 0, false
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	return string(key), nil
+}
+
+// ParseJobFilter reads the history filters from the query string of r.
+// Supported parameters: runType, profile, status (success|failure), verdict (pass|fail), failedStep, from, to (RFC3339), cursor, limit.
+func ParseJobFilter(r *http.Request) (JobFilter, error) {
+	q := r.URL.Query()
+	filter := JobFilter{Profile: q.Get("profile"), Cursor: q.Get("cursor"), Limit: defaultHistoryLimit}
+
+	if v := q.Get("runType"); v != "" {
+		var rt RunType
+		if err := rt.UnmarshalJSON([]byte(strconv.Quote(v))); err != nil {
+			return filter, err
+		}
+		filter.RunType = &rt
+	}
+
+	if v := q.Get("status"); v != "" {
+		var success bool
+		switch v {
+		case "success":
+			success = true
+		case "failure":
+			success = false
+		default:
+			return filter, fmt.Errorf("invalid status %q (expected success or failure)", v)
+		}
+		filter.Success = &success
+	}
+
+	if v := q.Get("verdict"); v != "" {
+		filter.Verdict = strings.ToUpper(v)
+		if filter.Verdict != VerdictPass && filter.Verdict != VerdictFail {
+			return filter, fmt.Errorf("invalid verdict %q (expected pass or fail)", v)
+		}
+	}
+
+	if v := q.Get("failedStep"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid failedStep %q", v)
+		}
+		step := Step(n)
+		filter.FailedStep = &step
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s time", name)
+			}
+			*dst = t
+		}
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+		filter.Limit = min(n, maxHistoryLimit)
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestFailedStep(t *testing.T) {
+	stages := func(statuses ...string) []StageResult {
+		list := make([]StageResult, len(statuses))
+		for i, status := range statuses {
+			list[i] = StageResult{Status: status}
+		}
+		return list
+	}
+
+	tests := []struct {
+		name   string
+		res    JobResult
+		want   Step
+		wantOK bool
+	}{
+		{name: "success", res: JobResult{Step: 2, Stages: stages(StageSucceeded, StageSucceeded, StageSucceeded)}},
+		{
+			name:   "failed stage",
+			res:    JobResult{Error: "exit status 1", Step: 1, Stages: stages(StageSucceeded, StageFailed, StageSkipped)},
+			want:   1,
+			wantOK: true,
+		},
+		{
+			name:   "stopped stage",
+			res:    JobResult{Error: "stopped by user", Step: 2, Stages: stages(StageSucceeded, StageSucceeded, StageCanceled)},
+			want:   2,
+			wantOK: true,
+		},
+		{
+			name:   "stored before stage statuses",
+			res:    JobResult{Error: "exit status 1", Step: 1, Stages: stages("", "")},
+			want:   1,
+			wantOK: true,
+		},
+		{name: "unknown profile", res: JobResult{Error: `unknown profile "nope"`}},
+		{name: "missed schedule", res: JobResult{Error: "missed: the runner was down", Step: 3}},
+		{name: "failed after its stages", res: JobResult{Error: "saving artifacts", Step: 1, Stages: stages(StageSucceeded, StageSucceeded)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := failedStep(tt.res)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("failedStep() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+
+			summary := NewJobSummary(tt.res)
+			if (summary.FailedStep != nil) != tt.wantOK || (tt.wantOK && *summary.FailedStep != tt.want) {
+				t.Errorf("NewJobSummary().FailedStep = %v, want %v", summary.FailedStep, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJobFilter(t *testing.T) {
+	scheduled, one, yes, no := Scheduled, Step(1), true, false
+
+	tests := []struct {
+		query   string
+		want    JobFilter
+		wantErr bool
+	}{
+		{query: "", want: JobFilter{Limit: defaultHistoryLimit}},
+		{
+			query: "runType=scheduled&profile=iad1bc-slab017&status=failure&verdict=fail&failedStep=1&limit=10&cursor=abc",
+			want: JobFilter{RunType: &scheduled, Profile: "iad1bc-slab017", Success: &no, Verdict: VerdictFail,
+				FailedStep: &one, Cursor: "abc", Limit: 10},
+		},
+		{query: "status=success", want: JobFilter{Success: &yes, Limit: defaultHistoryLimit}},
+		{
+			query: "from=2026-03-04T00:00:00Z&to=2026-03-05T00:00:00-05:00",
+			want: JobFilter{From: time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC),
+				To: time.Date(2026, 3, 5, 0, 0, 0, 0, time.FixedZone("", -5*3600)), Limit: defaultHistoryLimit},
+		},
+		{query: "limit=100000", want: JobFilter{Limit: maxHistoryLimit}},
+		{query: "runType=nightly", wantErr: true},
+		{query: "status=ok", wantErr: true},
+		{query: "verdict=maybe", wantErr: true},
+		{query: "failedStep=first", wantErr: true},
+		{query: "from=yesterday", wantErr: true},
+		{query: "limit=0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := ParseJobFilter(httptest.NewRequest(http.MethodGet, "/api/jobs?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseJobFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprintf("%+v", deref(got)) != fmt.Sprintf("%+v", deref(tt.want)) {
+				t.Errorf("ParseJobFilter() = %+v, want %+v", deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+// deref replaces a filter's pointers with what they point to, so filters print comparably.
+func deref(f JobFilter) map[string]any {
+	out := map[string]any{"Profile": f.Profile, "Verdict": f.Verdict, "From": f.From, "To": f.To, "Cursor": f.Cursor, "Limit": f.Limit}
+	if f.RunType != nil {
+		out["RunType"] = *f.RunType
+	}
+	if f.Success != nil {
+		out["Success"] = *f.Success
+	}
+	if f.FailedStep != nil {
+		out["FailedStep"] = *f.FailedStep
+	}
+	return out
+}
+
+func TestJobFilterMatch(t *testing.T) {
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	res := JobResult{
+		RunType:   Scheduled,
+		Profile:   "IAD1BC-slab017",
+		StartTime: start,
+		Error:     "exit status 1",
+		Verdict:   VerdictFail,
+		Step:      1,
+		Stages:    []StageResult{{Status: StageSucceeded}, {Status: StageFailed}},
+	}
+	manual, scheduled, zero, one, yes, no := Manual, Scheduled, Step(0), Step(1), true, false
+
+	tests := []struct {
+		name   string
+		filter JobFilter
+		want   bool
+	}{
+		{"no filters", JobFilter{}, true},
+		{"run type", JobFilter{RunType: &scheduled}, true},
+		{"other run type", JobFilter{RunType: &manual}, false},
+		{"profile ignores case", JobFilter{Profile: "iad1bc-SLAB017"}, true},
+		{"other profile", JobFilter{Profile: "iad1bc-slab001"}, false},
+		{"failure", JobFilter{Success: &no}, true},
+		{"success", JobFilter{Success: &yes}, false},
+		{"verdict", JobFilter{Verdict: VerdictFail}, true},
+		{"other verdict", JobFilter{Verdict: VerdictPass}, false},
+		{"failed step", JobFilter{FailedStep: &one}, true},
+		{"other failed step", JobFilter{FailedStep: &zero}, false},
+		{"from is inclusive", JobFilter{From: start}, true},
+		{"after from", JobFilter{From: start.Add(time.Second)}, false},
+		{"to is exclusive", JobFilter{To: start}, false},
+		{"before to", JobFilter{To: start.Add(time.Second)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(res); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// a run that never reached a stage has no failed step to filter on
+	if (JobFilter{FailedStep: &zero}).Match(JobResult{Error: `unknown profile "nope"`}) {
+		t.Error("Match() matched a run without a failed stage")
+	}
+}
+
+func TestJobsHandlerCursor(t *testing.T) {
+	app := &App{Store: NewMemoryStore()}
+	r := chi.NewRouter()
+	RegisterJobHandlers(r, app)
+
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		res := JobResult{ID: fmt.Sprintf("job-%d", i), StartTime: at, EndTime: at.Add(time.Minute), Profile: "iad1bc-slab017"}
+		if i%2 == 1 {
+			res.Profile = "iad1bc-slab001"
+		}
+		app.Store.SaveResult(res)
+	}
+
+	get := func(query string) (int, []string, string) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/jobs?"+query, nil))
+
+		var body struct {
+			Jobs       []JobSummary `json:"jobs"`
+			NextCursor string       `json:"nextCursor"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+
+		ids := make([]string, len(body.Jobs))
+		for i, job := range body.Jobs {
+			ids[i] = job.ID
+		}
+		return rec.Code, ids, body.NextCursor
+	}
+
+	// the cursor keeps the filter's place, newest first
+	var ids []string
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("/api/jobs does not stop paging")
+		}
+		code, page, next := get("profile=iad1bc-slab017&limit=2&cursor=" + cursor)
+		if code != http.StatusOK {
+			t.Fatalf("status = %d", code)
+		}
+		ids, cursor = append(ids, page...), next
+	}
+	if want := "[job-4 job-2 job-0]"; fmt.Sprint(ids) != want {
+		t.Errorf("pages = %v, want %v", ids, want)
+	}
+
+	if code, _, _ := get("cursor=%25%25%25"); code != http.StatusBadRequest {
+		t.Errorf("invalid cursor: status = %d, want 400", code)
+	}
+	if code, _, _ := get("status=maybe"); code != http.StatusBadRequest {
+		t.Errorf("invalid filter: status = %d, want 400", code)
+	}
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// hostKeyChecker verifies the host key presented by every target host, against the host's pinned
+// fingerprints when it has any and otherwise against the known_hosts file.
+type hostKeyChecker struct {
+	knownHostsPath  string
+	trustOnFirstUse bool
+	mutex           sync.Mutex // serializes reading and appending known_hosts
+}
+
+func newHostKeyChecker(cfg SSHConfig) *hostKeyChecker {
+	return &hostKeyChecker{knownHostsPath: cfg.KnownHosts, trustOnFirstUse: cfg.TrustOnFirstUse}
+}
+
+// Callback returns the host key callback for the named host.
+func (c *hostKeyChecker) Callback(name string, pinned []string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := ssh.FingerprintSHA256(key)
+
+		if len(pinned) > 0 {
+			if slices.Contains(pinned, presented) {
+				return nil
+			}
+			return fmt.Errorf("host key mismatch for %s (%s): expected %s, presented %s",
+				name, hostname, strings.Join(pinned, " or "), presented)
+		}
+
+		return c.checkKnownHosts(name, hostname, remote, key)
+	}
+}
+
+func (c *hostKeyChecker) checkKnownHosts(name, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	presented := ssh.FingerprintSHA256(key)
+
+	err := c.knownHostsCallback()(hostname, remote, key)
+
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return nil
+
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		expected := make([]string, len(keyErr.Want))
+		for i, want := range keyErr.Want {
+			expected[i] = ssh.FingerprintSHA256(want.Key)
+		}
+		return fmt.Errorf("host key mismatch for %s (%s): expected %s, presented %s",
+			name, hostname, strings.Join(expected, " or "), presented)
+
+	case errors.As(err, &keyErr) && c.trustOnFirstUse:
+		if err := c.record(hostname, key); err != nil {
+			return fmt.Errorf("failed to record host key of %s: %w", name, err)
+		}
+		slog.Warn("trusting new host key on first use", "host", name, "address", hostname, "fingerprint", presented)
+		return nil
+
+	case errors.As(err, &keyErr):
+		return fmt.Errorf("host key of %s (%s) is not known (presented %s): add it to %s, pin its fingerprint or enable ssh.trust_on_first_use",
+			name, hostname, presented, c.knownHostsPath)
+
+	default:
+		return fmt.Errorf("host key check for %s failed: %w", name, err)
+	}
+}
+
+// Algorithms returns the host key algorithms to offer for addr (host:port): those of the key types
+// known_hosts records for it, so the server presents a key that can be verified. It returns nil,
+// offering every algorithm, for hosts with pinned fingerprints and for hosts known_hosts has no
+// entry for (which only connect under trust on first use).
+func (c *hostKeyChecker) Algorithms(addr string, pinned []string) []string {
+	if len(pinned) > 0 {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// a key known_hosts cannot hold makes the callback list every key it has for addr
+	var keyErr *knownhosts.KeyError
+	if err := c.knownHostsCallback()(addr, &net.TCPAddr{IP: net.IPv4zero}, probeKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		for _, algo := range keyAlgorithms(known.Key.Type()) {
+			if !slices.Contains(algorithms, algo) {
+				algorithms = append(algorithms, algo)
+			}
+		}
+	}
+
+	return algorithms
+}
+
+// keyAlgorithms returns the signature algorithms of a key type: RSA keys sign with SHA-2 or, on
+// old servers, SHA-1; every other type names its one algorithm.
+func keyAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	}
+
+	return []string{keyType}
+}
+
+// probeKey is a public key no known_hosts file holds.
+type probeKey struct{}
+
+func (probeKey) Type() string                        { return "routetest-probe" }
+func (probeKey) Marshal() []byte                     { return []byte("routetest-probe") }
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key") }
+
+// knownHostsCallback reads the known_hosts file; a missing file knows no hosts.
+func (c *hostKeyChecker) knownHostsCallback() ssh.HostKeyCallback {
+	callback, err := knownhosts.New(c.knownHostsPath)
+	if err == nil {
+		return callback
+	}
+
+	if !os.IsNotExist(err) {
+		return func(string, net.Addr, ssh.PublicKey) error {
+			return fmt.Errorf("failed to read %s: %w", c.knownHostsPath, err)
+		}
+	}
+
+	return func(string, net.Addr, ssh.PublicKey) error {
+		return &knownhosts.KeyError{}
+	}
+}
+
+// record appends a host key to the known_hosts file, creating it when needed.
+func (c *hostKeyChecker) record(hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(c.knownHostsPath), 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(c.knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+
+	return err
+}
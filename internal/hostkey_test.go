@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	ed25519Key := newEd25519Signer(t).PublicKey()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		keys   []ssh.PublicKey
+		addr   string
+		pinned []string
+		want   []string
+	}{
+		{name: "ed25519 entry", keys: []ssh.PublicKey{ed25519Key}, addr: "10.9.4.21:22", want: []string{ssh.KeyAlgoED25519}},
+		{
+			name: "rsa and ed25519 entries",
+			keys: []ssh.PublicKey{rsaPub, ed25519Key},
+			addr: "10.9.4.21:22",
+			want: []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA, ssh.KeyAlgoED25519},
+		},
+		{name: "other port", keys: []ssh.PublicKey{ed25519Key}, addr: "10.9.4.21:2222"},
+		{name: "unknown host", keys: []ssh.PublicKey{ed25519Key}, addr: "10.9.4.22:22"},
+		{name: "pinned fingerprint", keys: []ssh.PublicKey{ed25519Key}, addr: "10.9.4.21:22", pinned: []string{ssh.FingerprintSHA256(rsaPub)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := &hostKeyChecker{knownHostsPath: writeKnownHosts(t, "10.9.4.21:22", tt.keys...)}
+
+			if got := checker.Algorithms(tt.addr, tt.pinned); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Algorithms() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	missing := &hostKeyChecker{knownHostsPath: filepath.Join(t.TempDir(), "known_hosts")}
+	if got := missing.Algorithms("10.9.4.21:22", nil); got != nil {
+		t.Errorf("Algorithms() without known_hosts = %v", got)
+	}
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	known, other := newEd25519Signer(t).PublicKey(), newEd25519Signer(t).PublicKey()
+	remote := &net.TCPAddr{IP: net.ParseIP("10.9.4.21"), Port: 22}
+
+	tests := []struct {
+		name    string
+		known   []ssh.PublicKey // in known_hosts
+		pinned  []string
+		tofu    bool
+		key     ssh.PublicKey // presented
+		wantErr string
+	}{
+		{name: "known key", known: []ssh.PublicKey{known}, key: known},
+		{name: "changed key", known: []ssh.PublicKey{known}, key: other, wantErr: "host key mismatch for sdvn"},
+		{name: "changed key under trust on first use", known: []ssh.PublicKey{known}, tofu: true, key: other, wantErr: "host key mismatch for sdvn"},
+		{name: "unknown host", key: known, wantErr: "is not known"},
+		{name: "unknown host under trust on first use", tofu: true, key: known},
+		{name: "pinned", pinned: []string{ssh.FingerprintSHA256(known)}, key: known},
+		{name: "pinned over known_hosts", known: []ssh.PublicKey{other}, pinned: []string{ssh.FingerprintSHA256(known)}, key: known},
+		{name: "not pinned", known: []ssh.PublicKey{other}, pinned: []string{ssh.FingerprintSHA256(known)}, key: other, wantErr: "expected " + ssh.FingerprintSHA256(known)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeKnownHosts(t, "10.9.4.21:22", tt.known...)
+			checker := &hostKeyChecker{knownHostsPath: path, trustOnFirstUse: tt.tofu}
+
+			err := checker.Callback("sdvn", tt.pinned)("10.9.4.21:22", remote, tt.key)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("callback error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("callback error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	key, other := newEd25519Signer(t).PublicKey(), newEd25519Signer(t).PublicKey()
+	remote := &net.TCPAddr{IP: net.ParseIP("10.9.4.21"), Port: 2222}
+
+	// the file and its directory are created for the first key
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	checker := &hostKeyChecker{knownHostsPath: path, trustOnFirstUse: true}
+
+	if err := checker.Callback("sdvn", nil)("10.9.4.21:2222", remote, key); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(data), "[10.9.4.21]:2222 ssh-ed25519 ") {
+		t.Fatalf("known_hosts = %q, %v", data, err)
+	}
+
+	// the recorded key is trusted, and another one is not, even on first use
+	if err := checker.Callback("sdvn", nil)("10.9.4.21:2222", remote, key); err != nil {
+		t.Errorf("recorded key: %v", err)
+	}
+	if err := checker.Callback("sdvn", nil)("10.9.4.21:2222", remote, other); err == nil {
+		t.Error("changed key after first use: want a mismatch")
+	}
+	if got := checker.Algorithms("10.9.4.21:2222", nil); fmt.Sprint(got) != "["+ssh.KeyAlgoED25519+"]" {
+		t.Errorf("Algorithms() after first use = %v", got)
+	}
+}
@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Latency segments of a route take
+const (
+	SegmentSchedulerRouter = "scheduler-router" // take command started → router log
+	SegmentRouterClient    = "router-client"    // router log → client service log
+	SegmentClientSlab      = "client-slab"      // client service log → slab LwrpUpdated
+	SegmentSchedulerSlab   = "scheduler-slab"   // the whole take
+)
+
+// LatencyStats sums up the samples of one latency segment, in milliseconds.
+type LatencyStats struct {
+	Segment string  `json:"segment"`
+	Samples int     `json:"samples"`
+	MinMs   float64 `json:"minMs"`
+	AvgMs   float64 `json:"avgMs"`
+	MaxMs   float64 `json:"maxMs"`
+}
+
+// String formats the stats for reports, e.g. "router-client: min 12ms, avg 15.5ms, max 19ms (3 samples)".
+func (s LatencyStats) String() string {
+	return fmt.Sprintf("%s: min %gms, avg %gms, max %gms (%d samples)", s.Segment, s.MinMs, s.AvgMs, s.MaxMs, s.Samples)
+}
+
+// latencyPoint is a moment of a route take: a take command's start or a timeline event.
+type latencyPoint struct {
+	time      time.Time
+	multicast string
+}
+
+// hops are the timeline event types of each stage of a take.
+var (
+	routerEvents = map[string]bool{EventRouteTake: true, EventRouteChange: true, EventRouteClear: true, EventSalvo: true}
+	clientEvents = map[string]bool{EventClientJoin: true, EventClientLeave: true, EventClient: true}
+	slabEvents   = map[string]bool{EventLwrpUpdated: true}
+)
+
+// routeLatency measures the segments of the route takes of a run: from each command marked
+// "take: true" to the router log, on to the client service log and the slab's LwrpUpdated, using
+// the timeline's parsed timestamps. Segments without samples are left out, so a run without take
+// commands only measures the hops between the logs.
+//
+// The take commands' starts are read on the runner's clock and the events on the clocks of the
+// hosts that logged them; the delays assume those clocks agree (NTP). Skew adds to or subtracts
+// from the segments that cross machines, and an event that seems to precede its take is not
+// paired with it at all.
+func routeLatency(res JobResult) []LatencyStats {
+	var scheduler []latencyPoint
+	for _, stage := range res.Stages {
+		for _, r := range lastAttempts(stage.Results) {
+			if r.Take {
+				scheduler = append(scheduler, latencyPoint{time: r.StartTime})
+			}
+		}
+	}
+
+	points := func(types map[string]bool) []latencyPoint {
+		var pts []latencyPoint
+		for _, event := range res.Timeline {
+			if types[event.Type] {
+				pts = append(pts, latencyPoint{time: event.Time, multicast: event.Multicast})
+			}
+		}
+		return pts
+	}
+	router, client, slab := points(routerEvents), points(clientEvents), points(slabEvents)
+
+	var stats []LatencyStats
+	for _, segment := range []struct {
+		name     string
+		from, to []latencyPoint
+	}{
+		{SegmentSchedulerRouter, scheduler, router},
+		{SegmentRouterClient, router, client},
+		{SegmentClientSlab, client, slab},
+		{SegmentSchedulerSlab, scheduler, slab},
+	} {
+		if s, ok := latencyStats(segment.name, hopLatencies(segment.from, segment.to)); ok {
+			stats = append(stats, s)
+		}
+	}
+
+	return stats
+}
+
+// hopLatencies pairs each point of to with the latest point of from at or before it (on the same
+// multicast group, when both name one) and returns, per from point, the delay to its first to
+// point. Both lists are in time order.
+func hopLatencies(from, to []latencyPoint) []time.Duration {
+	first := map[int]time.Duration{}
+	var order []int
+
+	for _, b := range to {
+		match := -1
+		for i, a := range from {
+			if a.time.After(b.time) {
+				break
+			}
+			if a.multicast == "" || b.multicast == "" || a.multicast == b.multicast {
+				match = i
+			}
+		}
+		if match < 0 {
+			continue
+		}
+
+		if _, ok := first[match]; !ok {
+			first[match] = b.time.Sub(from[match].time)
+			order = append(order, match)
+		}
+	}
+
+	delays := make([]time.Duration, len(order))
+	for i, idx := range order {
+		delays[i] = first[idx]
+	}
+
+	return delays
+}
+
+// latencyStats sums up the delays of a segment; false when there are none.
+func latencyStats(segment string, delays []time.Duration) (LatencyStats, bool) {
+	if len(delays) == 0 {
+		return LatencyStats{}, false
+	}
+
+	minD, maxD, total := delays[0], delays[0], time.Duration(0)
+	for _, d := range delays {
+		minD, maxD = min(minD, d), max(maxD, d)
+		total += d
+	}
+
+	return LatencyStats{
+		Segment: segment,
+		Samples: len(delays),
+		MinMs:   durationMs(minD),
+		AvgMs:   durationMs(total / time.Duration(len(delays))),
+		MaxMs:   durationMs(maxD),
+	}, true
+}
+
+// durationMs returns d in milliseconds, to the microsecond.
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// LatencyTrendPoint is the latency of one stored run.
+type LatencyTrendPoint struct {
+	ID        string         `json:"id"`
+	StartTime time.Time      `json:"startTime"`
+	Profile   string         `json:"profile,omitempty"`
+	Verdict   string         `json:"verdict,omitempty"`
+	Latency   []LatencyStats `json:"latency"`
+}
+
+// latencyResults returns every stored run matching filter that measured a latency, newest first,
+// and the number of matching runs. Its cursor and limit are ignored: it reads all pages.
+func (app *App) latencyResults(filter JobFilter) ([]JobResult, int, error) {
+	filter.Cursor, filter.Limit = "", maxHistoryLimit
+
+	var results []JobResult
+	total := 0
+
+	for {
+		page, next, err := app.Store.ListResults(filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		total += len(page)
+
+		// keep only what the trend shows, not every run's output
+		for _, res := range page {
+			if len(res.Latency) > 0 {
+				results = append(results, JobResult{ID: res.ID, StartTime: res.StartTime, Profile: res.Profile, Verdict: res.Verdict, Latency: res.Latency})
+			}
+		}
+
+		if next == "" {
+			return results, total, nil
+		}
+		filter.Cursor = next
+	}
+}
+
+// latencyTrend returns the runs of results that measured a latency, in the order given, and the
+// stats of each segment over all of them.
+func latencyTrend(results []JobResult) ([]LatencyTrendPoint, []LatencyStats) {
+	points := []LatencyTrendPoint{}
+	segments := map[string]*LatencyStats{}
+	var order []string
+
+	for _, res := range results {
+		if len(res.Latency) == 0 {
+			continue
+		}
+
+		points = append(points, LatencyTrendPoint{
+			ID:        res.ID,
+			StartTime: res.StartTime,
+			Profile:   res.Profile,
+			Verdict:   res.Verdict,
+			Latency:   res.Latency,
+		})
+
+		for _, s := range res.Latency {
+			total, ok := segments[s.Segment]
+			if !ok {
+				total = &LatencyStats{Segment: s.Segment, MinMs: s.MinMs, MaxMs: s.MaxMs}
+				segments[s.Segment] = total
+				order = append(order, s.Segment)
+			}
+
+			// the average over all samples, weighting each run by its sample count
+			total.AvgMs = (total.AvgMs*float64(total.Samples) + s.AvgMs*float64(s.Samples)) / float64(total.Samples+s.Samples)
+			total.Samples += s.Samples
+			total.MinMs = min(total.MinMs, s.MinMs)
+			total.MaxMs = max(total.MaxMs, s.MaxMs)
+		}
+	}
+
+	overall := make([]LatencyStats, 0, len(order))
+	for _, name := range order {
+		s := *segments[name]
+		s.AvgMs = math.Round(s.AvgMs*1000) / 1000
+		overall = append(overall, s)
+	}
+
+	return points, overall
+}
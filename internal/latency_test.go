@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHopLatencies(t *testing.T) {
+	base := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	at := func(ms int, multicast string) latencyPoint {
+		return latencyPoint{time: base.Add(time.Duration(ms) * time.Millisecond), multicast: multicast}
+	}
+	ms := func(values ...int) []time.Duration {
+		delays := make([]time.Duration, len(values))
+		for i, v := range values {
+			delays[i] = time.Duration(v) * time.Millisecond
+		}
+		return delays
+	}
+
+	tests := []struct {
+		name     string
+		from, to []latencyPoint
+		want     []time.Duration
+	}{
+		{
+			name: "first event after each start",
+			from: []latencyPoint{at(0, ""), at(1000, "")},
+			to:   []latencyPoint{at(40, ""), at(60, ""), at(1025, "")},
+			want: ms(40, 25),
+		},
+		{
+			name: "paired with the latest start before it",
+			from: []latencyPoint{at(0, ""), at(10, "")},
+			to:   []latencyPoint{at(30, "")},
+			want: ms(20),
+		},
+		{
+			name: "same multicast group",
+			from: []latencyPoint{at(0, "239.10.64.203"), at(10, "239.10.64.210")},
+			to:   []latencyPoint{at(30, "239.10.64.203"), at(45, "239.10.64.210")},
+			want: ms(30, 35),
+		},
+		{
+			name: "a point without a group pairs with any",
+			from: []latencyPoint{at(0, "239.10.64.203")},
+			to:   []latencyPoint{at(12, "")},
+			want: ms(12),
+		},
+		{
+			// e.g. the host's clock runs behind the runner's
+			name: "events before every start",
+			from: []latencyPoint{at(100, "")},
+			to:   []latencyPoint{at(50, "")},
+			want: ms(),
+		},
+		{
+			name: "at the same time",
+			from: []latencyPoint{at(0, "")},
+			to:   []latencyPoint{at(0, "")},
+			want: ms(0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hopLatencies(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hopLatencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteLatency(t *testing.T) {
+	base := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+
+	res := JobResult{
+		Stages: []StageResult{{
+			Name: "Scheduler",
+			Test: true,
+			Results: []CommandResult{
+				{Command: "date", Attempt: 1, StartTime: at(-500)},
+				{Command: "python3 scheduler_script.py", Attempt: 1, StartTime: at(-200), Take: true},
+				{Command: "python3 scheduler_script.py", Attempt: 2, StartTime: at(0), Take: true},
+				{Command: "echo Done with scheduler", Attempt: 1, StartTime: at(900)},
+			},
+		}},
+		Timeline: []RouteEvent{
+			{Time: at(40), Type: EventRouteTake, Multicast: "239.10.64.203"},
+			{Time: at(55), Type: EventClientJoin, Multicast: "239.10.64.203"},
+			{Time: at(1000), Type: EventLwrpUpdated, Multicast: "239.10.64.203"},
+		},
+	}
+
+	// only the final attempt of the take command starts the take
+	want := []LatencyStats{
+		{Segment: SegmentSchedulerRouter, Samples: 1, MinMs: 40, AvgMs: 40, MaxMs: 40},
+		{Segment: SegmentRouterClient, Samples: 1, MinMs: 15, AvgMs: 15, MaxMs: 15},
+		{Segment: SegmentClientSlab, Samples: 1, MinMs: 945, AvgMs: 945, MaxMs: 945},
+		{Segment: SegmentSchedulerSlab, Samples: 1, MinMs: 1000, AvgMs: 1000, MaxMs: 1000},
+	}
+	if got := routeLatency(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("routeLatency() = %v, want %v", got, want)
+	}
+
+	// without a take command only the hops between the logs are measured
+	for i := range res.Stages[0].Results {
+		res.Stages[0].Results[i].Take = false
+	}
+	if got := routeLatency(res); len(got) != 2 || got[0].Segment != SegmentRouterClient || got[1].Segment != SegmentClientSlab {
+		t.Errorf("routeLatency() without takes = %v", got)
+	}
+}
+
+func TestLatencyTrend(t *testing.T) {
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	results := []JobResult{
+		{ID: "job-3", StartTime: start.Add(2 * time.Hour), Latency: []LatencyStats{
+			{Segment: SegmentRouterClient, Samples: 1, MinMs: 30, AvgMs: 30, MaxMs: 30},
+		}},
+		{ID: "job-2", StartTime: start.Add(time.Hour)}, // measured nothing
+		{ID: "job-1", StartTime: start, Profile: "iad1bc-slab017", Verdict: VerdictPass, Latency: []LatencyStats{
+			{Segment: SegmentRouterClient, Samples: 3, MinMs: 10, AvgMs: 20, MaxMs: 25},
+			{Segment: SegmentClientSlab, Samples: 2, MinMs: 100, AvgMs: 110, MaxMs: 120},
+		}},
+	}
+
+	runs, segments := latencyTrend(results)
+
+	if len(runs) != 2 || runs[0].ID != "job-3" || runs[1].ID != "job-1" || runs[1].Profile != "iad1bc-slab017" || runs[1].Verdict != VerdictPass {
+		t.Errorf("runs = %+v", runs)
+	}
+
+	// the average weights each run by its samples: (30*1 + 20*3) / 4
+	want := []LatencyStats{
+		{Segment: SegmentRouterClient, Samples: 4, MinMs: 10, AvgMs: 22.5, MaxMs: 30},
+		{Segment: SegmentClientSlab, Samples: 2, MinMs: 100, AvgMs: 110, MaxMs: 120},
+	}
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("segments = %v, want %v", segments, want)
+	}
+
+	if runs, segments := latencyTrend(nil); runs == nil || len(runs) != 0 || len(segments) != 0 {
+		t.Errorf("latencyTrend(nil) = %v, %v, want empty lists", runs, segments)
+	}
+}
+
+func TestLatencyResults(t *testing.T) {
+	app := &App{Store: NewMemoryStore()}
+
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < maxHistoryLimit+10; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		res := JobResult{ID: fmt.Sprintf("job-%d", i), StartTime: at, EndTime: at.Add(time.Second), SDVNOutput: "lots of output"}
+		if i%2 == 0 {
+			res.Latency = []LatencyStats{{Segment: SegmentRouterClient, Samples: 1, MinMs: 1, AvgMs: 1, MaxMs: 1}}
+		}
+		app.Store.SaveResult(res)
+	}
+
+	// the whole range, whatever the page
+	results, total, err := app.latencyResults(JobFilter{Limit: 5, Cursor: "ignored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != maxHistoryLimit+10 || len(results) != (maxHistoryLimit+10)/2 {
+		t.Errorf("latencyResults() = %d results of %d, want %d of %d", len(results), total, (maxHistoryLimit+10)/2, maxHistoryLimit+10)
+	}
+	if results[0].ID != fmt.Sprintf("job-%d", maxHistoryLimit+8) || results[0].SDVNOutput != "" {
+		t.Errorf("latencyResults()[0] = %+v, want the newest run without its output", results[0])
+	}
+}
@@ -1,76 +1,96 @@
 package internal
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
 )
 
 // LocalJobTarget defines a set of CLI commands to be executed locally as a single job.
 type LocalJobTarget struct {
-	Label    string   // Example: "local", "preflight", etc.
-	Commands []string // Each shell command to execute, sequentially
+	Label    string          // Example: "local", "preflight", etc.
+	Commands []CommandConfig // Each shell command to execute, sequentially
+	Window   TestWindow      // passed to the commands as ROUTETEST_WINDOW_START/END
 }
 
 // localRunCmd executes all commands in target.Commands locally on the running host,
-// in order, appending stdout+stderr for each. If any command fails or if the context is canceled,
-// execution stops and the error/output is returned. Activity is reported for each stage.
-func localRunCmd(ctx context.Context, app *App, target LocalJobTarget) (string, error) {
+// in order, appending stdout+stderr for each and recording a CommandResult per attempt. Each command runs under its timeout/retries/allow_failure
+// policy; if a command still fails or if the context is canceled, execution stops and the error/output
+// is returned. Activity is reported for each stage.
+func localRunCmd(ctx context.Context, app *App, target LocalJobTarget) (string, []CommandResult, error) {
 	app.SetJobActivity(fmt.Sprintf("Preparing to run local commands for %s...", target.Label))
 
-	var combinedOutput strings.Builder
+	var log commandLog
 
 	for i, cmd := range target.Commands {
-		app.SetJobActivity(fmt.Sprintf("Running command %d/%d locally (%s):\n%s",
-			i+1, len(target.Commands), target.Label, cmd,
-		))
-
-		// Note: split cmd for exec.Command—this lets users do ["bash", "-c", "script.sh"] or just "script.sh"
-		var c *exec.Cmd
-		if parts := strings.Fields(cmd); len(parts) > 1 {
-			c = exec.CommandContext(ctx, parts[0], parts[1:]...)
-		} else {
-			c = exec.CommandContext(ctx, cmd)
+		where := fmt.Sprintf("command %d/%d locally (%s)", i+1, len(target.Commands), target.Label)
+		app.SetJobActivity(fmt.Sprintf("Running %s:\n%s", where, cmd.Run))
+
+		err := app.runCommandPolicy(ctx, cmd, where, &log, func(ctx context.Context) (*outputBuffer, *outputBuffer, error) {
+			return localRunAttempt(ctx, app, target.Label, i+1, cmd.Run, target.Window.env())
+		})
+		if err == context.Canceled {
+			return log.output.String(), log.results, fmt.Errorf("local job stopped by user")
 		}
+		if err != nil {
+			return log.output.String(), log.results, err
+		}
+	}
 
-		var outBuf, errBuf bytes.Buffer
-		c.Stdout = &outBuf
-		c.Stderr = &errBuf
+	return log.output.String(), log.results, nil
+}
 
-		err := c.Start()
-		if err != nil {
-			combinedOutput.WriteString(fmt.Sprintf(
-				"Failed to start command: %q\nError: %v\n", cmd, err,
-			))
+// localRunAttempt runs one command and returns its stdout and stderr, kept within the job's output limits. When ctx ends first (job
+// stopped or command timed out) the process is killed.
+func localRunAttempt(ctx context.Context, app *App, label string, index int, cmd string, env []string) (*outputBuffer, *outputBuffer, error) {
+	// Note: split cmd for exec.Command—this lets users do ["bash", "-c", "script.sh"] or just "script.sh"
+	var c *exec.Cmd
+	if parts := strings.Fields(cmd); len(parts) > 1 {
+		c = exec.Command(parts[0], parts[1:]...)
+	} else {
+		c = exec.Command(cmd)
+	}
 
-			return combinedOutput.String(), err
-		}
+	c.Env = append(os.Environ(), env...)
 
-		// Wait for completion or cancel/context done
-		waitDone := make(chan error, 1)
-		go func() { waitDone <- c.Wait() }()
+	outBuf := app.newOutputBuffer(label, index, "stdout")
+	errBuf := app.newOutputBuffer(label, index, "stderr")
+	outLines := app.newLineWriter(label, index, "stdout")
+	errLines := app.newLineWriter(label, index, "stderr")
 
-		select {
-		case <-ctx.Done():
-			app.SetJobActivity(fmt.Sprintf("Cancelling local command: %s", cmd))
+	c.Stdout = io.MultiWriter(outBuf, outLines)
+	c.Stderr = io.MultiWriter(errBuf, errLines)
 
-			_ = c.Process.Kill() // Best effort; sends SIGKILL
-			<-waitDone
+	if err := c.Start(); err != nil {
+		outBuf.Finish()
+		errBuf.Finish()
+		return nil, nil, fmt.Errorf("failed to start command: %w", err)
+	}
 
-			combinedOutput.WriteString(fmt.Sprintf("[CANCELED] Command: %s\nOutput:\n%s%s\n", cmd, outBuf.String(), errBuf.String()))
-			return combinedOutput.String(), fmt.Errorf("local job stopped by user")
+	// Wait for completion or cancel/timeout
+	waitDone := make(chan error, 1)
+	go func() { waitDone <- c.Wait() }()
 
-		case err := <-waitDone:
-			combinedOutput.WriteString(fmt.Sprintf("Command: %s\nOutput:\n%s%s\n", cmd, outBuf.String(), errBuf.String()))
+	var err error
 
-			if err != nil {
-				combinedOutput.WriteString(fmt.Sprintf("[ERROR] Command failed: %v\n", err))
-				return combinedOutput.String(), err
-			}
-		}
+	select {
+	case <-ctx.Done():
+		app.SetJobActivity(fmt.Sprintf("Stopping local command: %s", cmd))
+
+		_ = c.Process.Kill() // Best effort; sends SIGKILL
+		<-waitDone
+		err = ctx.Err()
+
+	case err = <-waitDone:
 	}
 
-	return combinedOutput.String(), nil
+	outLines.Flush()
+	errLines.Flush()
+	outBuf.Finish()
+	errBuf.Finish()
+
+	return outBuf, errBuf, err
 }
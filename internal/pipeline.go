@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stage statuses
+const (
+	StageSucceeded = "success"
+	StageFailed    = "failed"
+	StageSkipped   = "skipped"  // not run because an earlier stage failed
+	StageCanceled  = "canceled" // stopped by the user
+)
+
+// StageResult is the outcome of one pipeline stage.
+type StageResult struct {
+	Name      string
+	Type      string
+	Host      string `json:",omitempty"`
+	Finally   bool   `json:",omitempty"` // a cleanup stage of the finally section
+	Test      bool   `json:",omitempty"` // a test stage, part of the test window
+	Status    string // success | failed | skipped | canceled
+	StartTime time.Time
+	EndTime   time.Time
+	Commands  []string `json:",omitempty"` // command lines as run, with the ssh working directory and environment
+	Output    string
+	Error     string          `json:",omitempty"`
+	Results   []CommandResult `json:",omitempty"` // one record per command attempt (ssh and local stages)
+	Logs      []LogEntry      `json:",omitempty"` // log entries found by a slab-logs stage
+}
+
+// Heading is the stage name followed by its status, e.g. "Cleanup [skipped]"; results stored
+// before stages had a status show the name only.
+func (s StageResult) Heading() string {
+	if s.Status == "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%s [%s]", s.Name, s.Status)
+}
+
+// MarshalJSON adds the Heading, so the frontend shows the same stage headings as the reports.
+func (s StageResult) MarshalJSON() ([]byte, error) {
+	type stageResult StageResult // without the methods, so it marshals field by field
+
+	return json.Marshal(struct {
+		stageResult
+		Heading string
+	}{stageResult(s), s.Heading()})
+}
+
+// backgroundStage is a background command started by an ssh-background stage.
+type backgroundStage struct {
+	handle *SSHPersistentHandle
+	index  int          // stage result that receives the captured output
+	stage  StageConfig  // its expect and parse are applied once the output is captured
+	target SSHJobTarget // its artifacts are collected once the command stops
+}
+
+// pipelineRun holds the state shared by the stages of a single run.
+type pipelineRun struct {
+	app         *App
+	result      *JobResult
+	backgrounds map[string]*backgroundStage // host → running background command
+	tempDirs    map[string]SSHJobTarget     // host → target whose temporary working directory is removed by Close
+}
+
+func newPipelineRun(app *App, result *JobResult) *pipelineRun {
+	return &pipelineRun{
+		app:         app,
+		result:      result,
+		backgrounds: map[string]*backgroundStage{},
+		tempDirs:    map[string]SSHJobTarget{},
+	}
+}
+
+// sshTarget resolves a stage's host reference into an SSH target.
+func (app *App) sshTarget(name string) SSHJobTarget {
+	host := app.Config.File.Hosts[name]
+	creds := app.Config.Credentials[name]
+
+	var jumps []SSHJobTarget
+	for _, hop := range host.ProxyJump {
+		jumps = append(jumps, app.sshTarget(hop))
+	}
+
+	target := SSHJobTarget{
+		Jumps:   jumps,
+		Label:   name,
+		HostKey: app.hostKeys.Callback(name, host.Options.HostKeyFingerprints),
+		IP:      host.Address,
+		Port:    host.Port,
+		Options: host.Options,
+		User:    creds.User,
+		Auth:    app.sshAuthMethods(host, creds),
+
+		Uploads:   host.Uploads,
+		WorkDir:   host.WorkDir,
+		Artifacts: host.Artifacts,
+	}
+	target.HostKeyAlgorithms = app.hostKeys.Algorithms(target.Addr(), host.Options.HostKeyFingerprints)
+
+	return target
+}
+
+// sshTarget is app.sshTarget for a stage of this run; a temporary working directory it uses is
+// kept for the later stages and removed by Close.
+func (run *pipelineRun) sshTarget(host string) SSHJobTarget {
+	target := run.app.sshTarget(host)
+	if target.WorkDir == workDirTemp {
+		run.tempDirs[host] = target
+	}
+
+	return target
+}
+
+// runStage executes stage i of the pipeline and records its result.
+func (run *pipelineRun) runStage(ctx context.Context, i int, stage StageConfig) error {
+	run.result.Stages = append(run.result.Stages, StageResult{
+		Name:      stage.Name,
+		Type:      stage.Type,
+		Host:      stage.Host,
+		Test:      stage.Test,
+		StartTime: time.Now(),
+	})
+
+	// the stage commands arrive rendered; ssh stages add their prefixes below
+	run.result.Stages[i].Commands = commandRuns(stage.Commands)
+
+	var output string
+	var results []CommandResult
+	var err error
+
+	switch stage.Type {
+	case StageSSH:
+		target := run.sshTarget(stage.Host)
+		target.Commands = stage.Commands
+		target.Window = run.window(time.Now())
+
+		for j, cmd := range stage.Commands {
+			run.result.Stages[i].Commands[j] = run.app.sshCommandLine(target, cmd.Run)
+		}
+
+		output, results, err = sshRunCmd(ctx, run.app, target)
+
+	case StageSSHBackground:
+		target := run.sshTarget(stage.Host)
+		target.Command = stage.Command
+		if stage.Capture {
+			target.Capture = newCappedBuffer(stage.MaxBytes, stage.MaxLines)
+		}
+		run.result.Stages[i].Commands = []string{inDir(run.app.workDirPath(target.WorkDir), stage.Command)}
+
+		var handle *SSHPersistentHandle
+		if handle, output, err = sshRunPersistentCmd(ctx, run.app, target); err == nil {
+			target.WorkDir = run.app.workDirPath(target.WorkDir)
+			run.backgrounds[stage.Host] = &backgroundStage{handle: handle, index: i, stage: stage, target: target}
+			run.app.AddPersistentHandle(handle)
+		}
+
+	case StageStopBackground:
+		// captured output is trimmed to the test window, so let the window's end pass first
+		window := run.window(time.Now())
+		if bg, ok := run.backgrounds[stage.Host]; ok && bg.handle.Output != nil {
+			if err = window.waitForEnd(ctx, run.app); err != nil {
+				break
+			}
+		}
+
+		run.app.SetJobActivity(fmt.Sprintf("Shutting down background command on %s", stage.Host))
+		run.stopBackground(ctx, stage.Host, window)
+
+	case StageLocal:
+		label := stage.Host
+		if label == "" {
+			label = stage.Name
+		}
+
+		target := LocalJobTarget{Label: label, Commands: stage.Commands, Window: run.window(time.Now())}
+		output, results, err = localRunCmd(ctx, run.app, target)
+
+	case StageSlabLogs:
+		output, run.result.Stages[i].Logs, err = slabLogsRunCmd(ctx, run.app, *stage.Search, run.window(time.Now()))
+	}
+
+	run.result.Stages[i].EndTime = time.Now()
+
+	run.result.Stages[i].Output = output
+	run.result.Stages[i].Results = results
+	run.result.Stages[i].Status = StageSucceeded
+	if err != nil {
+		run.result.Stages[i].Error = err.Error()
+		run.result.Stages[i].Status = StageFailed
+		if ctx.Err() == context.Canceled {
+			run.result.Stages[i].Status = StageCanceled
+		}
+	}
+	run.result.appendLegacyOutput(stage.Host, output)
+
+	// a running background command is checked and parsed when it stops
+	if _, running := run.backgrounds[stage.Host]; stage.Type != StageSSHBackground || !running {
+		run.finishStage(i, stage)
+	}
+
+	return err
+}
+
+// skipStage records stage i as not run.
+func (run *pipelineRun) skipStage(i int, stage StageConfig) {
+	run.result.Stages = append(run.result.Stages, StageResult{
+		Name:   stage.Name,
+		Type:   stage.Type,
+		Host:   stage.Host,
+		Status: StageSkipped,
+	})
+
+	run.finishStage(i, stage)
+}
+
+// finishStage checks the expect rules of stage i once its output is complete and adds the route
+// events in the output to the timeline.
+func (run *pipelineRun) finishStage(i int, stage StageConfig) {
+	run.evaluateExpect(i, stage.Expect)
+	run.parseEvents(i, stage.Parse)
+}
+
+// stopBackground closes the background command running on host, keeps its captured output that
+// arrived within the test window and collects the host's artifacts.
+func (run *pipelineRun) stopBackground(ctx context.Context, host string, window TestWindow) {
+	bg, ok := run.backgrounds[host]
+	if !ok {
+		return
+	}
+
+	// the files the command wrote are complete once it is stopped
+	var artifacts strings.Builder
+	bg.handle.Stop()
+	run.app.collectArtifacts(ctx, bg.handle.Connection, bg.target, &artifacts)
+
+	bg.handle.Close()
+	run.app.RemovePersistentHandle(bg.handle)
+	delete(run.backgrounds, host)
+
+	if err := bg.handle.Connection.Err(); err != nil {
+		run.result.Stages[bg.index].Error = err.Error()
+		run.result.Stages[bg.index].Status = StageFailed
+	}
+
+	if bg.handle.Output != nil {
+		output := bg.handle.Output.Between(window)
+		run.result.Stages[bg.index].Output += output // after the upload log
+
+		if bg.stage.tailOutput {
+			run.result.SdvnTailOutput = output
+		}
+	}
+	run.result.Stages[bg.index].Output += artifacts.String()
+
+	run.finishStage(bg.index, bg.stage)
+}
+
+// stopBackgrounds stops every background command that is still running.
+func (run *pipelineRun) stopBackgrounds(ctx context.Context) {
+	window := run.window(time.Now())
+	for host := range run.backgrounds {
+		run.stopBackground(ctx, host, window)
+	}
+}
+
+// Close stops the background commands and removes the temporary working directories of the run.
+func (run *pipelineRun) Close() {
+	run.stopBackgrounds(context.Background())
+
+	for host, target := range run.tempDirs {
+		run.app.removeWorkDir(target)
+		delete(run.tempDirs, host)
+	}
+}
+
+// appendLegacyOutput keeps the per-host output fields of JobResult filled for existing clients.
+func (res *JobResult) appendLegacyOutput(host, output string) {
+	switch host {
+	case "scheduler":
+		res.SchedulerOutput += output
+	case "sdvn":
+		res.SDVNOutput += output
+	case "slab":
+		res.SlabOutput += output
+	}
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageResultJSON(t *testing.T) {
+	data, err := json.Marshal(StageResult{Name: "Cleanup", Status: StageSkipped, Output: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["Heading"] != "Cleanup [skipped]" || got["Name"] != "Cleanup" || got["Output"] != "x" {
+		t.Errorf("Marshal() = %s", data)
+	}
+
+	// the heading is derived, so a stored result decodes without it
+	var back StageResult
+	if err := json.Unmarshal(data, &back); err != nil || back.Name != "Cleanup" || back.Status != StageSkipped {
+		t.Errorf("Unmarshal() = %+v, %v", back, err)
+	}
+}
+
+func TestLegacyPipelineTailOutput(t *testing.T) {
+	cfg := FileConfig{Sdvn: HostConfig{BackgroundCmd: "tail -F /var/log/magnum.log", CaptureBackground: true}}
+
+	for _, stage := range legacyPipeline(cfg) {
+		if want := stage.Name == "Start Log"; stage.tailOutput != want {
+			t.Errorf("stage %q tailOutput = %v, want %v", stage.Name, stage.tailOutput, want)
+		}
+	}
+}
+
+// localStage returns a local stage that runs cmd.
+func localStage(name, cmd string, continueOnError bool) StageConfig {
+	return StageConfig{Name: name, Type: StageLocal, Host: "local", Commands: []CommandConfig{{Run: cmd}}, ContinueOnError: continueOnError}
+}
+
+func TestExecuteRunnerTasksStages(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline []StageConfig
+		finally  []StageConfig
+		stop     bool // stop the job while its first stage runs
+		want     string
+		wantErr  string // start of the job error
+		wantStep Step
+	}{
+		{
+			name:     "success",
+			pipeline: []StageConfig{localStage("Take", "true", false), localStage("Slab", "true", false)},
+			finally:  []StageConfig{localStage("Cleanup", "true", false)},
+			want:     "[Take [success] Slab [success] Cleanup [success]]",
+			wantStep: 3,
+		},
+		{
+			name:     "failure skips the rest but not finally",
+			pipeline: []StageConfig{localStage("Take", "false", false), localStage("Slab", "true", false)},
+			finally:  []StageConfig{localStage("Cleanup", "true", false)},
+			want:     "[Take [failed] Slab [skipped] Cleanup [success]]",
+			wantErr:  "Take error: ",
+			wantStep: 0,
+		},
+		{
+			name:     "continue on error",
+			pipeline: []StageConfig{localStage("Take", "false", true), localStage("Slab", "true", false)},
+			finally:  []StageConfig{localStage("Cleanup", "true", false)},
+			want:     "[Take [failed] Slab [success] Cleanup [success]]",
+			wantErr:  "Take error: ",
+			wantStep: 0,
+		},
+		{
+			name:     "first error is reported",
+			pipeline: []StageConfig{localStage("Take", "false", true), localStage("Slab", "false", false)},
+			finally:  []StageConfig{localStage("Cleanup", "false", false)},
+			want:     "[Take [failed] Slab [failed] Cleanup [failed]]",
+			wantErr:  "Take error: ",
+			wantStep: 0,
+		},
+		{
+			name:     "failing cleanup",
+			pipeline: []StageConfig{localStage("Take", "true", false)},
+			finally:  []StageConfig{localStage("Cleanup", "true", false), localStage("Unlock", "false", false), localStage("Report", "true", false)},
+			want:     "[Take [success] Cleanup [success] Unlock [failed] Report [success]]",
+			wantErr:  "Unlock error: ",
+			wantStep: 2,
+		},
+		{
+			name:     "stopped",
+			pipeline: []StageConfig{localStage("Take", "sleep 5", true), localStage("Slab", "true", false)},
+			finally:  []StageConfig{localStage("Cleanup", "true", false)},
+			stop:     true,
+			want:     "[Take [canceled] Slab [skipped] Cleanup [success]]",
+			wantErr:  "local job stopped by user",
+			wantStep: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewApp(&AppConfig{File: FileConfig{
+				Pipeline:  tt.pipeline,
+				Finally:   tt.finally,
+				Output:    OutputConfig{MaxCommandBytes: 64 << 10, MaxJobBytes: 1 << 20},
+				Artifacts: ArtifactsConfig{Dir: t.TempDir()},
+			}}, NewMemoryStore())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.stop {
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+
+			result := app.ExecuteRunnerTasks(ctx, RunRequest{ID: "job-1"})
+
+			var stages []string
+			for i, stage := range result.Stages {
+				stages = append(stages, stage.Heading())
+				if stage.Finally != (i >= len(tt.pipeline)) {
+					t.Errorf("stage %q: Finally = %v", stage.Name, stage.Finally)
+				}
+			}
+			if fmt.Sprint(stages) != tt.want {
+				t.Errorf("stages = %v, want %v", stages, tt.want)
+			}
+			if tt.wantErr == "" && result.Error != "" || !strings.HasPrefix(result.Error, tt.wantErr) {
+				t.Errorf("Error = %q, want %q", result.Error, tt.wantErr)
+			}
+			if result.Step != tt.wantStep {
+				t.Errorf("Step = %d, want %d", result.Step, tt.wantStep)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnknownProfile is returned when a run or schedule names a profile that is not configured.
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// Built-in template variables set for every run; request params cannot override them.
+const (
+	paramRunID     = "RunID"
+	paramStartTime = "StartTime"
+)
+
+// Profile returns the named profile, or the default profile when name is empty. Without a default
+// profile an empty name selects no profile at all.
+func (app *App) Profile(name string) (ProfileConfig, error) {
+	name = strings.ToLower(name)
+	if name == "" {
+		name = app.Config.File.DefaultProfile
+	}
+	if name == "" {
+		return ProfileConfig{}, nil
+	}
+
+	profile, ok := app.Config.File.Profiles[name]
+	if !ok {
+		return ProfileConfig{}, fmt.Errorf("%w %q", ErrUnknownProfile, name)
+	}
+
+	return profile, nil
+}
+
+// Profiles returns the configured profiles sorted by name.
+func (app *App) Profiles() []ProfileConfig {
+	list := make([]ProfileConfig, 0, len(app.Config.File.Profiles))
+	for _, profile := range app.Config.File.Profiles {
+		list = append(list, profile)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// paramValueRx limits the values of request params, which are templated into shell commands
+// unquoted, to characters without a meaning to the shell.
+var paramValueRx = regexp.MustCompile(`^[A-Za-z0-9._:/-]*$`)
+
+// runParams returns the template data of a run: the profile params, overridden by the params of
+// the run request, plus the built-in RunID and StartTime. Every param is stored under its template
+// field (see templateName), so the param slab is used as {{.Slab}}. Request param values must
+// match paramValueRx.
+func runParams(profile ProfileConfig, overrides map[string]string, runID string, start time.Time, fields map[string]bool) (map[string]any, error) {
+	params := make(map[string]any, len(profile.Params)+len(overrides)+2)
+
+	for name, value := range profile.Params {
+		params[templateName(name, fields)] = value
+	}
+
+	for name, value := range overrides {
+		if strings.EqualFold(name, paramRunID) || strings.EqualFold(name, paramStartTime) {
+			return nil, fmt.Errorf("param %q is reserved", name)
+		}
+		if !paramValueRx.MatchString(value) {
+			return nil, fmt.Errorf("param %q: invalid value %q (only letters, digits and . _ : / - are allowed)", name, value)
+		}
+		params[templateName(name, fields)] = value
+	}
+
+	params[paramRunID] = runID
+	params[paramStartTime] = start
+
+	return params, nil
+}
+
+// templateName turns a param name into its template field: the field of the pipeline templates
+// that matches it case-insensitively, since profile param names arrive lower-cased from the config
+// loader (mcastgroup → {{.McastGroup}}), or else the name with a leading capital.
+func templateName(name string, fields map[string]bool) string {
+	for field := range fields {
+		if strings.EqualFold(field, name) {
+			return field
+		}
+	}
+
+	r, size := utf8.DecodeRuneInString(name)
+
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// pipelineFields returns the top-level fields referenced by the templates of stages.
+func pipelineFields(stages []StageConfig) (map[string]bool, error) {
+	fields := map[string]bool{}
+	for _, stage := range stages {
+		for _, cmd := range stage.templates() {
+			if err := templateFields(cmd, fields); err != nil {
+				return nil, fmt.Errorf("stage %q: %w", stage.Name, err)
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// ValidateRunRequest resolves the profile and params of req and renders every command of the
+// pipeline, so a run with unknown or missing variables is rejected before any host is contacted.
+func (app *App) ValidateRunRequest(req RunRequest) error {
+	profile, err := app.Profile(req.Profile)
+	if err != nil {
+		return err
+	}
+
+	used, err := pipelineFields(app.Config.File.AllStages())
+	if err != nil {
+		return err
+	}
+
+	params, err := runParams(profile, req.Params, req.ID, time.Now(), used)
+	if err != nil {
+		return err
+	}
+
+	for name := range req.Params {
+		if field := templateName(name, used); !used[field] {
+			return fmt.Errorf("unknown param %q: no command uses {{.%s}}", name, field)
+		}
+	}
+
+	_, err = renderPipeline(app.Config.File.AllStages(), params)
+
+	return err
+}
+
+// renderPipeline returns a copy of stages with every command rendered from params.
+func renderPipeline(stages []StageConfig, params map[string]any) ([]StageConfig, error) {
+	rendered := make([]StageConfig, len(stages))
+
+	for i, stage := range stages {
+		commands, err := renderCommands(stage.Commands, params)
+		if err == nil {
+			stage.Command, err = renderCommand(stage.Command, params)
+		}
+		if err == nil && stage.Search != nil {
+			search := *stage.Search
+			for _, field := range []*string{&search.Slab, &search.Dst, &search.Mcast} {
+				if *field, err = renderCommand(*field, params); err != nil {
+					break
+				}
+			}
+			stage.Search = &search
+		}
+		if err == nil && stage.Expect != nil {
+			stage.Expect, err = renderExpect(stage.Expect, params)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stage %q: %w", stage.Name, err)
+		}
+
+		stage.Commands = commands
+		rendered[i] = stage
+	}
+
+	return rendered, nil
+}
+
+// templates returns every template string of the stage.
+func (stage StageConfig) templates() []string {
+	templates := append([]string{stage.Command}, commandRuns(stage.Commands)...)
+	if stage.Search != nil {
+		templates = append(templates, stage.Search.Slab, stage.Search.Dst, stage.Search.Mcast)
+	}
+	for _, rule := range stage.Expect {
+		templates = append(templates, rule.Match, rule.NotMatch)
+	}
+
+	return templates
+}
+
+// templateFields adds the top-level fields referenced by a command template (e.g. Slab for
+// {{.Slab}}) to fields.
+func templateFields(cmd string, fields map[string]bool) error {
+	if !strings.Contains(cmd, "{{") {
+		return nil
+	}
+
+	tmpl, err := template.New("command").Parse(cmd)
+	if err != nil {
+		return fmt.Errorf("invalid command template: %w", err)
+	}
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, child := range n.Nodes {
+					walk(child)
+				}
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n != nil {
+				for _, c := range n.Cmds {
+					walk(c)
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			fields[n.Ident[0]] = true
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+	walk(tmpl.Tree.Root)
+
+	return nil
+}
+
+// renderCommand fills the {{.Name}} placeholders of a command from params. A placeholder without
+// a value is an error.
+func renderCommand(cmd string, params map[string]any) (string, error) {
+	if !strings.Contains(cmd, "{{") {
+		return cmd, nil
+	}
+
+	tmpl, err := template.New("command").Option("missingkey=error").Parse(cmd)
+	if err != nil {
+		return "", fmt.Errorf("invalid command template: %w", err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, params); err != nil {
+		return "", fmt.Errorf("failed to render command: %w", err)
+	}
+
+	return out.String(), nil
+}
+
+// renderCommands renders every command of a stage.
+func renderCommands(cmds []CommandConfig, params map[string]any) ([]CommandConfig, error) {
+	rendered := make([]CommandConfig, len(cmds))
+	for i, cmd := range cmds {
+		var err error
+		if cmd.Run, err = renderCommand(cmd.Run, params); err != nil {
+			return nil, err
+		}
+		rendered[i] = cmd
+	}
+
+	return rendered, nil
+}
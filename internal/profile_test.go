@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateName(t *testing.T) {
+	fields := map[string]bool{"Slab": true, "McastGroup": true}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"slab", "Slab"},
+		{"mcastGroup", "McastGroup"},
+		{"mcastgroup", "McastGroup"}, // profile params arrive lower-cased from the config loader
+		{"MCASTGROUP", "McastGroup"},
+		{"dstPort", "DstPort"}, // no placeholder uses it: leading capital only
+	}
+
+	for _, tt := range tests {
+		if got := templateName(tt.name, fields); got != tt.want {
+			t.Errorf("templateName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	profiles := map[string]ProfileConfig{
+		"p1": {Name: "p1", Params: map[string]string{"slab": "slab017"}},
+		"p2": {Name: "p2", Params: map[string]string{"slab": "slab018"}},
+	}
+
+	tests := []struct {
+		name        string
+		defaultName string
+		profile     string
+		want        string
+		wantErr     bool
+	}{
+		{name: "named", profile: "p2", want: "p2"},
+		{name: "name case", profile: "P2", want: "p2"},
+		{name: "default", defaultName: "p1", want: "p1"},
+		{name: "named over default", defaultName: "p1", profile: "p2", want: "p2"},
+		{name: "no default", want: ""},
+		{name: "unknown", defaultName: "p1", profile: "p3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{Config: &AppConfig{File: FileConfig{Profiles: profiles, DefaultProfile: tt.defaultName}}}
+
+			got, err := app.Profile(tt.profile)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnknownProfile) {
+					t.Fatalf("Profile() error = %v, want ErrUnknownProfile", err)
+				}
+				return
+			}
+			if err != nil || got.Name != tt.want {
+				t.Fatalf("Profile() = %q, %v, want %q", got.Name, err, tt.want)
+			}
+		})
+	}
+
+	app := &App{Config: &AppConfig{File: FileConfig{Profiles: profiles}}}
+	var names []string
+	for _, profile := range app.Profiles() {
+		names = append(names, profile.Name)
+	}
+	if fmt.Sprint(names) != "[p1 p2]" {
+		t.Errorf("Profiles() = %v, want [p1 p2]", names)
+	}
+}
+
+func TestValidateRunRequest(t *testing.T) {
+	app := &App{Config: &AppConfig{File: FileConfig{
+		Pipeline: []StageConfig{{
+			Name: "Slab", Type: StageLocal,
+			Commands: []CommandConfig{{Run: "slab.py -slab {{.Slab}} -mcast {{.McastGroup}} -run {{.RunID}}"}},
+		}},
+		Profiles: map[string]ProfileConfig{
+			"p1": {Name: "p1", Params: map[string]string{"slab": "slab017", "mcastgroup": "239.10.64.203"}},
+		},
+		DefaultProfile: "p1",
+	}}}
+
+	tests := []struct {
+		name    string
+		req     RunRequest
+		wantErr string
+	}{
+		{"profile params", RunRequest{}, ""},
+		{"multi-word override", RunRequest{Params: map[string]string{"mcastGroup": "239.1.1.1"}}, ""},
+		{"unknown param", RunRequest{Params: map[string]string{"dst": "6"}}, "unknown param"},
+		{"reserved param", RunRequest{Params: map[string]string{"runid": "x"}}, "reserved"},
+		{"shell in a value", RunRequest{Params: map[string]string{"slab": "slab017; rm -rf /"}}, "invalid value"},
+		{"command substitution", RunRequest{Params: map[string]string{"slab": "$(reboot)"}}, "invalid value"},
+		{"url value", RunRequest{Params: map[string]string{"slab": "http://10.9.4.21:9200/x_y-z"}}, ""},
+		{"unknown profile", RunRequest{Profile: "nope"}, "unknown profile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := app.ValidateRunRequest(tt.req)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ValidateRunRequest() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("ValidateRunRequest() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderPipelineMissingParam(t *testing.T) {
+	stages := []StageConfig{{Name: "Slab", Commands: []CommandConfig{{Run: "slab.py -dst {{.Dst}}"}}}}
+
+	params, err := runParams(ProfileConfig{}, nil, "id", time.Now(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderPipeline(stages, params); err == nil {
+		t.Fatal("renderPipeline() rendered a placeholder without a value")
+	}
+}
+
+func TestRunParams(t *testing.T) {
+	profile := ProfileConfig{Name: "p1", Params: map[string]string{"slab": "slab017", "mcastgroup": "239.10.64.203"}}
+	fields := map[string]bool{"Slab": true, "McastGroup": true, "Dst": true}
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		want      map[string]any
+		wantErr   string
+	}{
+		{
+			name: "profile params",
+			want: map[string]any{"Slab": "slab017", "McastGroup": "239.10.64.203"},
+		},
+		{
+			name:      "overrides",
+			overrides: map[string]string{"MCASTGROUP": "239.1.1.1", "dst": "6"},
+			want:      map[string]any{"Slab": "slab017", "McastGroup": "239.1.1.1", "Dst": "6"},
+		},
+		{name: "run id", overrides: map[string]string{"RunID": "x"}, wantErr: "reserved"},
+		{name: "start time", overrides: map[string]string{"starttime": "x"}, wantErr: "reserved"},
+		{name: "quote", overrides: map[string]string{"dst": `6" && reboot "`}, wantErr: "invalid value"},
+		{name: "pipe", overrides: map[string]string{"dst": "6|sh"}, wantErr: "invalid value"},
+		{name: "empty value", overrides: map[string]string{"dst": ""}, want: map[string]any{"Slab": "slab017", "McastGroup": "239.10.64.203", "Dst": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := runParams(profile, tt.overrides, "job-1", start, fields)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("runParams() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runParams() error = %v", err)
+			}
+
+			tt.want[paramRunID] = "job-1"
+			tt.want[paramStartTime] = start
+			if fmt.Sprint(params) != fmt.Sprint(tt.want) {
+				t.Errorf("runParams() = %v, want %v", params, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderPipeline(t *testing.T) {
+	params := map[string]any{"Slab": "slab017", "Dst": "6", "Mcast": "239.10.64.203", paramRunID: "job-1"}
+
+	stages := []StageConfig{
+		{
+			Name:     "Take",
+			Commands: []CommandConfig{{Run: "take.sh {{.Dst}} {{.Mcast}}", Retries: 2}, {Run: "status"}},
+			Expect:   []ExpectConfig{{Match: "DST {{.Dst}}"}},
+		},
+		{Name: "Tail", Command: "tail -F /var/log/{{.RunID}}.log"},
+		{Name: "Slab", Search: &SlabSearchConfig{URL: "http://10.9.0.69:9200", Slab: "{{.Slab}}", Dst: "{{.Dst}}", Mcast: "{{.Mcast}}"}},
+	}
+
+	rendered, err := renderPipeline(stages, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"command", rendered[0].Commands[0].Run, "take.sh 6 239.10.64.203"},
+		{"command policy", fmt.Sprint(rendered[0].Commands[0].Retries), "2"},
+		{"plain command", rendered[0].Commands[1].Run, "status"},
+		{"expect", rendered[0].Expect[0].Match, "DST 6"},
+		{"background command", rendered[1].Command, "tail -F /var/log/job-1.log"},
+		{"search", fmt.Sprint(rendered[2].Search.Slab, " ", rendered[2].Search.Dst, " ", rendered[2].Search.Mcast), "slab017 6 239.10.64.203"},
+		{"search url", rendered[2].Search.URL, "http://10.9.0.69:9200"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// the configured stages are left alone for the next run
+	if stages[0].Commands[0].Run != "take.sh {{.Dst}} {{.Mcast}}" || stages[1].Command != "tail -F /var/log/{{.RunID}}.log" || stages[2].Search.Slab != "{{.Slab}}" {
+		t.Errorf("renderPipeline() changed the configured stages: %+v", stages)
+	}
+
+	if _, err := renderPipeline(stages[2:], map[string]any{"Slab": "slab017"}); err == nil || !strings.Contains(err.Error(), `stage "Slab"`) {
+		t.Errorf("renderPipeline() without Dst: error = %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/robfig/cron/v3"
+)
+
+// Recurrence types accepted by the schedules API
+const (
+	RecurCron   = "cron"
+	RecurDaily  = "daily"
+	RecurWeekly = "weekly"
+)
+
+// Recurrence describes a repeating schedule: a standard 5-field cron expression,
+// or a daily/weekly pattern at a wall-clock time, optionally in a named timezone.
+type Recurrence struct {
+	Type     string   `json:"type"`               // cron | daily | weekly
+	Cron     string   `json:"cron,omitempty"`     // e.g. "30 2 * * 1-5" (type cron)
+	At       string   `json:"at,omitempty"`       // "15:04" (type daily/weekly)
+	Weekdays []string `json:"weekdays,omitempty"` // e.g. ["mon", "thu"] (type weekly)
+	Timezone string   `json:"timezone,omitempty"` // IANA name, defaults to the server's local zone
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks the recurrence is complete and that its expression and timezone parse.
+func (r *Recurrence) Validate() error {
+	if r.Timezone != "" {
+		if _, err := time.LoadLocation(r.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", r.Timezone)
+		}
+	}
+
+	switch r.Type {
+	case RecurCron:
+		if r.Cron == "" {
+			return fmt.Errorf("cron recurrence requires a cron expression")
+		}
+		if strings.HasPrefix(r.Cron, "TZ=") || strings.HasPrefix(r.Cron, "CRON_TZ=") {
+			return fmt.Errorf("use the timezone field instead of a TZ prefix in the cron expression")
+		}
+	case RecurDaily, RecurWeekly:
+		if _, _, err := r.atTime(); err != nil {
+			return err
+		}
+		if _, err := r.weekdays(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid recurrence type %q (expected cron, daily or weekly)", r.Type)
+	}
+
+	if _, err := cron.ParseStandard(r.spec()); err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+
+	return nil
+}
+
+// atTime returns the hour and minute of a daily/weekly pattern.
+func (r *Recurrence) atTime() (uint, uint, error) {
+	at, err := time.Parse("15:04", r.At)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", r.At)
+	}
+
+	return uint(at.Hour()), uint(at.Minute()), nil
+}
+
+// weekdays returns the days of a weekly pattern.
+func (r *Recurrence) weekdays() ([]time.Weekday, error) {
+	if r.Type != RecurWeekly {
+		return nil, nil
+	}
+	if len(r.Weekdays) == 0 {
+		return nil, fmt.Errorf("weekly recurrence requires at least one weekday")
+	}
+
+	days := make([]time.Weekday, 0, len(r.Weekdays))
+	for _, name := range r.Weekdays {
+		key := strings.ToLower(name)
+		day, ok := weekdayNames[key[:min(3, len(key))]]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", name)
+		}
+		days = append(days, day)
+	}
+
+	return days, nil
+}
+
+// spec returns the recurrence as a cron expression, prefixed with its timezone when one is set.
+func (r *Recurrence) spec() string {
+	var spec string
+
+	switch r.Type {
+	case RecurCron:
+		spec = r.Cron
+	default:
+		hour, minute, _ := r.atTime()
+		dow := "*"
+		if days, _ := r.weekdays(); len(days) > 0 {
+			nums := make([]string, len(days))
+			for i, d := range days {
+				nums[i] = fmt.Sprint(int(d))
+			}
+			dow = strings.Join(nums, ",")
+		}
+		spec = fmt.Sprintf("%d %d * * %s", minute, hour, dow)
+	}
+
+	if r.Timezone != "" {
+		spec = fmt.Sprintf("CRON_TZ=%s %s", r.Timezone, spec)
+	}
+
+	return spec
+}
+
+// jobDefinition returns the gocron definition for the recurrence. Daily and weekly patterns in the
+// scheduler's own timezone use gocron's DailyJob/WeeklyJob; everything else runs as a CronJob.
+func (r *Recurrence) jobDefinition() gocron.JobDefinition {
+	if r.Timezone == "" && r.Type != RecurCron {
+		hour, minute, _ := r.atTime()
+		atTimes := gocron.NewAtTimes(gocron.NewAtTime(hour, minute, 0))
+
+		if days, _ := r.weekdays(); len(days) > 0 {
+			return gocron.WeeklyJob(1, gocron.NewWeekdays(days[0], days[1:]...), atTimes)
+		}
+
+		return gocron.DailyJob(1, atTimes)
+	}
+
+	return gocron.CronJob(r.spec(), false)
+}
+
+// Next returns up to n fire times strictly after from.
+func (r *Recurrence) Next(from time.Time, n int) []time.Time {
+	return r.between(from, time.Time{}, n)
+}
+
+// between returns up to max fire times after from and before until (no upper bound when until is zero).
+func (r *Recurrence) between(from, until time.Time, max int) []time.Time {
+	sched, err := cron.ParseStandard(r.spec())
+	if err != nil {
+		return nil
+	}
+
+	var times []time.Time
+	for t := sched.Next(from); !t.IsZero() && len(times) < max; t = sched.Next(t) {
+		if !until.IsZero() && t.After(until) {
+			break
+		}
+		times = append(times, t)
+	}
+
+	return times
+}
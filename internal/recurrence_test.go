@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       Recurrence
+		wantErr bool
+	}{
+		{"cron", Recurrence{Type: RecurCron, Cron: "30 2 * * 1-5"}, false},
+		{"cron without expression", Recurrence{Type: RecurCron}, true},
+		{"cron with tz prefix", Recurrence{Type: RecurCron, Cron: "CRON_TZ=UTC 30 2 * * *"}, true},
+		{"cron with bad expression", Recurrence{Type: RecurCron, Cron: "61 2 * * *"}, true},
+		{"daily", Recurrence{Type: RecurDaily, At: "02:30"}, false},
+		{"daily with bad time", Recurrence{Type: RecurDaily, At: "2:30pm"}, true},
+		{"weekly", Recurrence{Type: RecurWeekly, At: "02:30", Weekdays: []string{"mon", "Thursday"}}, false},
+		{"weekly without weekdays", Recurrence{Type: RecurWeekly, At: "02:30"}, true},
+		{"weekly with bad weekday", Recurrence{Type: RecurWeekly, At: "02:30", Weekdays: []string{"xyz"}}, true},
+		{"timezone", Recurrence{Type: RecurDaily, At: "02:30", Timezone: "America/New_York"}, false},
+		{"bad timezone", Recurrence{Type: RecurDaily, At: "02:30", Timezone: "Mars/Base"}, true},
+		{"unknown type", Recurrence{Type: "hourly"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.r.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+
+	// Wednesday 2026-03-04 12:00 UTC
+	from := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		r    Recurrence
+		n    int
+		want []time.Time
+	}{
+		{
+			name: "daily",
+			r:    Recurrence{Type: RecurDaily, At: "02:30", Timezone: "UTC"},
+			n:    2,
+			want: []time.Time{
+				time.Date(2026, 3, 5, 2, 30, 0, 0, time.UTC),
+				time.Date(2026, 3, 6, 2, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "weekly",
+			r:    Recurrence{Type: RecurWeekly, At: "08:00", Weekdays: []string{"mon", "thu"}, Timezone: "UTC"},
+			n:    3,
+			want: []time.Time{
+				time.Date(2026, 3, 5, 8, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 12, 8, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "cron",
+			r:    Recurrence{Type: RecurCron, Cron: "0 */6 * * *", Timezone: "UTC"},
+			n:    2,
+			want: []time.Time{
+				time.Date(2026, 3, 4, 18, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			// 2026-03-08 is the spring-forward day in New York; the wall-clock time is kept
+			name: "daily across DST",
+			r:    Recurrence{Type: RecurDaily, At: "09:00", Timezone: "America/New_York"},
+			n:    5,
+			want: []time.Time{
+				time.Date(2026, 3, 4, 9, 0, 0, 0, ny), // from is 07:00 in New York
+				time.Date(2026, 3, 5, 9, 0, 0, 0, ny),
+				time.Date(2026, 3, 6, 9, 0, 0, 0, ny),
+				time.Date(2026, 3, 7, 9, 0, 0, 0, ny),
+				time.Date(2026, 3, 8, 9, 0, 0, 0, ny),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.r.Next(from, tt.n)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Next() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("Next()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecurrenceBetween(t *testing.T) {
+	r := Recurrence{Type: RecurDaily, At: "02:30", Timezone: "UTC"}
+	from := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	if got := r.between(from, from.Add(72*time.Hour), 100); len(got) != 3 {
+		t.Errorf("between() over 3 days = %d times, want 3", len(got))
+	}
+	if got := r.between(from, time.Time{}, 4); len(got) != 4 {
+		t.Errorf("between() capped at 4 = %d times, want 4", len(got))
+	}
+}
+
+func TestScheduleConflict(t *testing.T) {
+	now := time.Now()
+	app := &App{schedules: map[string]*Schedule{
+		// fires every five minutes, far more often than any fixed occurrence cap
+		"busy": {ID: "busy", Recurrence: &Recurrence{Type: RecurCron, Cron: "*/5 * * * *"}},
+	}}
+
+	tests := []struct {
+		name  string
+		sched Schedule
+		want  string
+	}{
+		{
+			name:  "weeks ahead of a frequent schedule",
+			sched: Schedule{Time: now.Add(20 * 24 * time.Hour)},
+			want:  "Schedule conflicts with an existing job",
+		},
+		{
+			name:  "past the conflict horizon",
+			sched: Schedule{Time: now.Add(conflictHorizon + 24*time.Hour)},
+		},
+		{
+			name:  "every minute",
+			sched: Schedule{Recurrence: &Recurrence{Type: RecurCron, Cron: "* * * * *"}},
+			want:  "Recurring schedule fires more often than the conflict window allows",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.scheduleConflict(&tt.sched, ""); got != tt.want {
+				t.Errorf("scheduleConflict() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	delete(app.schedules, "busy")
+	app.schedules["daily"] = &Schedule{ID: "daily", Recurrence: &Recurrence{Type: RecurDaily, At: "02:30", Timezone: "UTC"}}
+
+	// a weekly schedule at the same time of day meets the daily one within the horizon
+	weekly := Schedule{Recurrence: &Recurrence{Type: RecurWeekly, At: "02:32", Weekdays: []string{"sun"}, Timezone: "UTC"}}
+	if got := app.scheduleConflict(&weekly, ""); got != "Schedule conflicts with an existing job" {
+		t.Errorf("scheduleConflict(weekly) = %q", got)
+	}
+	if got := app.scheduleConflict(&weekly, "daily"); got != "" {
+		t.Errorf("scheduleConflict(weekly) except the daily one = %q", got)
+	}
+}
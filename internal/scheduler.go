@@ -4,18 +4,70 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
+)
+
+// Catch-up policies for schedules missed while the service was down
+const (
+	CatchUpSkip     = "skip"
+	CatchUpRun      = "run"
+	CatchUpNextSlot = "next_slot"
+)
+
+const (
+	scheduleConflictWindow = time.Minute * 5
+	catchUpDelay           = time.Second * 10 // grace period before a caught-up schedule runs
+
+	// recurring schedules are expanded over this horizon when checking for conflicts. More fire
+	// times than maxConflictOccurrences within it means two are closer than the conflict window,
+	// so the cap only ever cuts short a schedule that fails the spacing check.
+	conflictHorizon        = time.Hour * 24 * 31
+	maxConflictOccurrences = int(conflictHorizon/scheduleConflictWindow) + 1
 )
 
 type Schedule struct {
-	ID        string    `json:"id"`
-	Time      time.Time `json:"time"`
-	IsPast    bool      `json:"isPast,omitempty"`
-	HasError  bool      `json:"hasError"`
-	IsRunning bool      `json:"isRunning"`
+	ID         string            `json:"id"`
+	Time       time.Time         `json:"time"` // run time, or the next fire time of a recurring schedule
+	Recurrence *Recurrence       `json:"recurrence,omitempty"`
+	Profile    string            `json:"profile,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`   // template params that override the profile's
+	NextRuns   []time.Time       `json:"nextRuns,omitempty"` // filled in by the API, not stored
+	IsPast     bool              `json:"isPast,omitempty"`
+	HasError   bool              `json:"hasError"`
+	IsRunning  bool              `json:"isRunning"`
+	Missed     bool              `json:"missed,omitempty"`
+	MissedTime *time.Time        `json:"missedTime,omitempty"` // original time of a missed schedule that was caught up
+
+	catchUpTime time.Time // when a missed run is caught up; set on startup only
+}
+
+// Upcoming returns up to n future fire times of the schedule.
+func (s *Schedule) Upcoming(n int) []time.Time {
+	now := time.Now()
+
+	if s.Recurrence != nil {
+		return s.Recurrence.Next(now, n)
+	}
+	if s.Time.After(now) && n > 0 {
+		return []time.Time{s.Time}
+	}
+
+	return nil
+}
+
+// occurrences returns the times considered for conflict checks: the run time of a one-time
+// schedule, or the fire times of a recurring schedule within the conflict horizon.
+func (s *Schedule) occurrences(now time.Time) []time.Time {
+	if s.Recurrence != nil {
+		return s.Recurrence.between(now, now.Add(conflictHorizon), maxConflictOccurrences)
+	}
+
+	return []time.Time{s.Time}
 }
 
 type ScheduleResult struct {
@@ -23,24 +75,47 @@ type ScheduleResult struct {
 	RunType RunType `json:"RunType"`
 }
 
-// checkScheduleConflict will return a conflict message if another schedule (other than exceptID) is within 5 minute of schedTime.
-func (app *App) CheckScheduleConflict(schedTime time.Time, exceptID string) string {
-	const conflictWindow = time.Minute * 5
-
+// checkScheduleConflict will return a conflict message if another schedule (other than exceptID) is within 5 minute of
+// any run of sched. Recurring schedules are compared by their fire times over the conflict horizon.
+func (app *App) CheckScheduleConflict(sched *Schedule, exceptID string) string {
 	app.scheduleMutex.Lock()
 	defer app.scheduleMutex.Unlock()
 
+	return app.scheduleConflict(sched, exceptID)
+}
+
+// scheduleConflict is CheckScheduleConflict for callers already holding scheduleMutex.
+func (app *App) scheduleConflict(sched *Schedule, exceptID string) string {
+	now := time.Now()
+
+	candidate := sched.occurrences(now)
+	if len(candidate) >= maxConflictOccurrences {
+		return "Recurring schedule fires more often than the conflict window allows"
+	}
+	for i := 1; i < len(candidate); i++ {
+		if candidate[i].Sub(candidate[i-1]) < scheduleConflictWindow {
+			return "Recurring schedule fires more often than the conflict window allows"
+		}
+	}
+
+	var existing []time.Time
 	for id, s := range app.schedules {
 		if id == exceptID {
 			continue
 		}
+		existing = append(existing, s.occurrences(now)...)
+	}
 
-		dt := s.Time.Sub(schedTime)
-		if dt < 0 {
-			dt = -dt
-		}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].Before(existing[j]) })
 
-		if dt < conflictWindow {
+	for _, t := range candidate {
+		// nearest existing occurrences on either side of t
+		i := sort.Search(len(existing), func(i int) bool { return !existing[i].Before(t) })
+
+		if i < len(existing) && existing[i].Sub(t) < scheduleConflictWindow {
+			return "Schedule conflicts with an existing job"
+		}
+		if i > 0 && t.Sub(existing[i-1]) < scheduleConflictWindow {
 			return "Schedule conflicts with an existing job"
 		}
 	}
@@ -53,14 +128,28 @@ func (app *App) AddScheduledJob(sched *Schedule) error {
 	app.scheduleMutex.Lock()
 	defer app.scheduleMutex.Unlock()
 
+	return app.addScheduledJob(sched)
+}
+
+// addScheduledJob is AddScheduledJob for callers already holding scheduleMutex.
+func (app *App) addScheduledJob(sched *Schedule) error {
 	// Remove existing job for this ID, if present
 	if job, ok := app.scheduleJobs[sched.ID]; ok {
 		app.scheduler.RemoveJob(job.ID())
 	}
 
 	// Defensive: ensure future time; in real app, also check that time is not past
+	definition := gocron.OneTimeJob(gocron.OneTimeJobStartDateTime(sched.Time))
+	if sched.Recurrence != nil {
+		definition = sched.Recurrence.jobDefinition()
+
+		if next := sched.Upcoming(1); len(next) > 0 {
+			sched.Time = next[0]
+		}
+	}
+
 	job, err := app.scheduler.NewJob(
-		gocron.OneTimeJob(gocron.OneTimeJobStartDateTime(sched.Time)),
+		definition,
 		gocron.NewTask(func() {
 			app.runScheduledJob(sched.ID)
 		}),
@@ -74,20 +163,193 @@ func (app *App) AddScheduledJob(sched *Schedule) error {
 	return nil
 }
 
+// SaveSchedule writes sched to the store; failures are logged since the in-memory schedule stays authoritative.
+func (app *App) SaveSchedule(sched *Schedule) {
+	if err := app.Store.SaveSchedule(*sched); err != nil {
+		slog.Error("failed to store schedule", "id", sched.ID, "error", err)
+	}
+}
+
+// RestoreSchedules loads the stored schedules on startup. Future schedules are re-registered with gocron,
+// schedules that were interrupted by a shutdown are marked failed, and schedules whose time passed while
+// the service was down are marked missed and handled according to the configured catch-up policy. For a
+// recurring schedule that time is its next fire time, so it catches up once however many runs it missed.
+func (app *App) RestoreSchedules() error {
+	stored, err := app.Store.ListSchedules()
+	if err != nil {
+		return err
+	}
+
+	// past schedules first, so catch-up slots are chosen around every known schedule
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Time.Before(stored[j].Time) })
+
+	app.scheduleMutex.Lock()
+	defer app.scheduleMutex.Unlock()
+
+	for i := range stored {
+		app.schedules[stored[i].ID] = &stored[i]
+	}
+
+	now := time.Now()
+
+	for i := range stored {
+		sched := &stored[i]
+
+		switch {
+		case sched.IsRunning:
+			slog.Warn("Schedule was interrupted by a shutdown", "id", sched.ID)
+
+			sched.IsRunning = false
+			sched.HasError = true
+
+			if sched.Recurrence == nil {
+				sched.IsPast = true
+			} else if err := app.addScheduledJob(sched); err != nil {
+				return fmt.Errorf("schedule %s: %w", sched.ID, err)
+			}
+
+		case sched.IsPast:
+			continue
+
+		case sched.Time.After(now):
+			if err := app.addScheduledJob(sched); err != nil {
+				return fmt.Errorf("schedule %s: %w", sched.ID, err)
+			}
+			continue
+
+		default:
+			if err := app.catchUpSchedule(sched, now); err != nil {
+				return fmt.Errorf("schedule %s: %w", sched.ID, err)
+			}
+		}
+
+		app.SaveSchedule(sched)
+	}
+
+	slog.Info("Restored schedules", "count", len(stored), "catchUp", app.Config.File.Schedules.CatchUp)
+
+	return nil
+}
+
+// catchUpSchedule applies the catch-up policy to a schedule whose time passed while the service was down.
+// Callers must hold scheduleMutex.
+func (app *App) catchUpSchedule(sched *Schedule, now time.Time) error {
+	missedTime := sched.Time
+	sched.Missed = true
+
+	slog.Warn("Schedule was missed while the service was down", "id", sched.ID, "time", missedTime, "policy", app.Config.File.Schedules.CatchUp)
+
+	slot := now.Add(catchUpDelay)
+
+	switch app.Config.File.Schedules.CatchUp {
+	case CatchUpRun:
+		// several missed schedules run one after another, a conflict window apart, so that
+		// none of them is skipped for finding the previous one still running
+		for app.caughtUpNear(slot, sched.ID) {
+			slot = slot.Add(scheduleConflictWindow)
+		}
+
+	case CatchUpNextSlot:
+		for app.scheduleConflict(&Schedule{Time: slot}, sched.ID) != "" || app.caughtUpNear(slot, sched.ID) {
+			slot = slot.Add(scheduleConflictWindow)
+		}
+
+	default:
+		sched.IsPast = sched.Recurrence == nil
+		sched.HasError = true
+
+		app.SetLastResult(JobResult{
+			ID:         uuid.New().String(),
+			ScheduleID: sched.ID,
+			StartTime:  now,
+			EndTime:    now,
+			Error:      fmt.Sprintf("Job missed: the service was not running at %s.", missedTime.Format(time.RFC3339)),
+			RunType:    Scheduled,
+			Trigger:    "schedule " + sched.ID,
+		})
+
+		if sched.Recurrence != nil {
+			return app.addScheduledJob(sched)
+		}
+
+		return nil
+	}
+
+	sched.MissedTime = &missedTime
+	sched.catchUpTime = slot
+
+	if sched.Recurrence == nil {
+		sched.Time = slot
+		return app.addScheduledJob(sched)
+	}
+
+	// a recurring schedule goes on firing on its recurrence; the missed run is an extra one-time job
+	if err := app.addScheduledJob(sched); err != nil {
+		return err
+	}
+
+	_, err := app.scheduler.NewJob(
+		gocron.OneTimeJob(gocron.OneTimeJobStartDateTime(slot)),
+		gocron.NewTask(func() {
+			app.runScheduledJob(sched.ID)
+		}),
+	)
+
+	return err
+}
+
+// caughtUpNear reports whether the missed run of a schedule other than exceptID is caught up within
+// the conflict window of t. Callers must hold scheduleMutex.
+func (app *App) caughtUpNear(t time.Time, exceptID string) bool {
+	for id, s := range app.schedules {
+		if id == exceptID || s.catchUpTime.IsZero() {
+			continue
+		}
+		if d := s.catchUpTime.Sub(t); d > -scheduleConflictWindow && d < scheduleConflictWindow {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Called by gocron callback for this schedule
 func (app *App) runScheduledJob(scheduleID string) {
 	slog.Info("Running Job Schedule", "id", scheduleID)
 
 	// Acquire lock for one-job-at-a-time
+	app.scheduleMutex.Lock()
+	sched, ok := app.schedules[scheduleID]
+	if !ok {
+		// deleted after gocron picked it up
+		app.scheduleMutex.Unlock()
+		slog.Warn("Schedule no longer exists", "id", scheduleID)
+		return
+	}
+	profile := sched.Profile
+	params := sched.Params
+	app.scheduleMutex.Unlock()
+
 	app.mutex.Lock()
 	if app.running {
 		app.mutex.Unlock()
 
 		// Optionally: record that the job was skipped due to a conflict
+		now := time.Now()
+		app.SetLastResult(JobResult{
+			ID:         uuid.New().String(),
+			ScheduleID: scheduleID,
+			StartTime:  now,
+			EndTime:    now,
+			Error:      "Job skipped: another job was already running.",
+			RunType:    Scheduled,
+			Trigger:    "schedule " + scheduleID,
+			Profile:    profile,
+			Params:     params,
+		})
+
 		app.scheduleMutex.Lock()
-		app.scheduleResults[scheduleID] = &ScheduleResult{Output: "Job skipped: another job was already running.\n\n", RunType: Scheduled}
-		app.schedules[scheduleID].IsPast = true
-		app.schedules[scheduleID].HasError = true
+		app.markScheduleRun(app.schedules[scheduleID], true)
 		app.scheduleMutex.Unlock()
 
 		slog.Error("Schedule Job skipped another job was already running", "id", scheduleID)
@@ -102,33 +364,97 @@ func (app *App) runScheduledJob(scheduleID string) {
 	// defer resetting of the app state
 	defer func() {
 		app.ResetApp()
-		app.schedules[scheduleID].IsRunning = false
+
+		app.scheduleMutex.Lock()
+		if sched, ok := app.schedules[scheduleID]; ok {
+			sched.IsRunning = false
+			app.SaveSchedule(sched)
+		}
+		app.scheduleMutex.Unlock()
 	}()
 
-	app.schedules[scheduleID].IsRunning = true
+	app.scheduleMutex.Lock()
+	if sched, ok := app.schedules[scheduleID]; ok {
+		sched.IsRunning = true
+		app.SaveSchedule(sched)
+	}
+	app.scheduleMutex.Unlock()
 
 	// ---- Execute the Tasks
-	result := app.ExecuteRunnerTasks(ctx, Scheduled)
+	result := app.ExecuteRunnerTasks(ctx, RunRequest{
+		RunType: Scheduled,
+		Trigger: "schedule " + scheduleID,
+		Profile: profile,
+		Params:  params,
+	})
+	result.ScheduleID = scheduleID
+
+	app.scheduleMutex.Lock()
+	app.markScheduleRun(app.schedules[scheduleID], result.Error != "")
+	app.scheduleMutex.Unlock()
 
+	// Store the result (even if manually canceled); it is also the last result
+	app.SetLastResult(result)
+}
+
+// markScheduleRun records the outcome of a schedule's run. One-time schedules become past entries,
+// recurring schedules move on to their next fire time. A schedule deleted while it ran (nil) is left
+// deleted. Callers must hold scheduleMutex.
+func (app *App) markScheduleRun(sched *Schedule, hasError bool) {
+	if sched == nil {
+		return
+	}
+
+	sched.HasError = hasError
+
+	if sched.Recurrence == nil {
+		sched.IsPast = true
+	} else if next := sched.Upcoming(1); len(next) > 0 {
+		sched.Time = next[0]
+	}
+
+	app.SaveSchedule(sched)
+}
+
+// NewScheduleResult flattens a stored JobResult into the report format shown on schedule cards
+func NewScheduleResult(result JobResult) *ScheduleResult {
 	var output strings.Builder
 
-	output.WriteString(fmt.Sprintf("Scheduler:\n%s\n\n", result.SchedulerOutput))
-	output.WriteString(fmt.Sprintf("SDVN:\n%s\n\n", result.SDVNOutput))
-	output.WriteString(fmt.Sprintf("Slab:\n%s\n", result.SlabOutput))
+	if result.Profile != "" {
+		output.WriteString(fmt.Sprintf("Profile: %s\n\n", result.Profile))
+	}
+
+	if len(result.Stages) > 0 {
+		for _, stage := range result.Stages {
+			output.WriteString(fmt.Sprintf("%s:\n%s\n\n", stage.Heading(), stage.Output))
+		}
+	} else {
+		// results stored before pipelines only have the per-host fields
+		output.WriteString(fmt.Sprintf("Scheduler:\n%s\n\n", result.SchedulerOutput))
+		output.WriteString(fmt.Sprintf("SDVN:\n%s\n\n", result.SDVNOutput))
+		if result.SdvnTailOutput != "" {
+			output.WriteString(fmt.Sprintf("SDVN Log Tail:\n%s\n\n", result.SdvnTailOutput))
+		}
+		output.WriteString(fmt.Sprintf("Slab:\n%s\n", result.SlabOutput))
+	}
+	if len(result.Latency) > 0 {
+		output.WriteString("Latency:\n")
+		for _, s := range result.Latency {
+			output.WriteString(s.String() + "\n")
+		}
+	}
+	if result.Verdict != "" {
+		output.WriteString(fmt.Sprintf("Verdict: %s\n", result.Verdict))
+		for _, a := range result.Assertions {
+			output.WriteString(a.String() + "\n")
+		}
+	}
 	if result.Error != "" {
-		output.WriteString(fmt.Sprintf("\nError:%s\n", result.SlabOutput))
+		output.WriteString(fmt.Sprintf("\nError:%s\n", result.Error))
 	}
 
-	// Store result for this schedule (even if manually canceled)
-	app.scheduleMutex.Lock()
-	app.scheduleResults[scheduleID] = &ScheduleResult{
+	return &ScheduleResult{
 		Output:  output.String(),
-		RunType: Scheduled,
+		RunType: result.RunType,
 	}
-	app.schedules[scheduleID].IsPast = true
-	app.schedules[scheduleID].HasError = result.Error != ""
-	app.scheduleMutex.Unlock()
-
-	// Set the last result
-	app.SetLastResult(result)
 }
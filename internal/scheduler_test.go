@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreSchedules(t *testing.T) {
+	daily := func() *Recurrence { return &Recurrence{Type: RecurDaily, At: "02:30", Timezone: "UTC"} }
+
+	// slotAfter reports whether t is the catch-up slot offset after the restore at start
+	slotAfter := func(t time.Time, start time.Time, offset time.Duration) bool {
+		d := t.Sub(start.Add(catchUpDelay + offset))
+		return d >= 0 && d < 5*time.Second
+	}
+
+	tests := []struct {
+		name    string
+		catchUp string
+		stored  func(now time.Time) []Schedule
+		check   func(t *testing.T, start time.Time, got map[string]*Schedule, results []JobResult)
+	}{
+		{
+			name:    "future schedule",
+			catchUp: CatchUpSkip,
+			stored: func(now time.Time) []Schedule {
+				return []Schedule{{ID: "later", Time: now.Add(time.Hour)}}
+			},
+			check: func(t *testing.T, start time.Time, got map[string]*Schedule, results []JobResult) {
+				if s := got["later"]; s.Missed || s.IsPast || s.HasError || len(results) != 0 {
+					t.Errorf("schedule = %+v, results = %d", s, len(results))
+				}
+			},
+		},
+		{
+			name:    "interrupted",
+			catchUp: CatchUpRun,
+			stored: func(now time.Time) []Schedule {
+				return []Schedule{
+					{ID: "once", Time: now.Add(-time.Minute), IsRunning: true},
+					{ID: "daily", Time: now.Add(-time.Minute), IsRunning: true, Recurrence: daily()},
+				}
+			},
+			check: func(t *testing.T, start time.Time, got map[string]*Schedule, results []JobResult) {
+				if s := got["once"]; s.IsRunning || !s.HasError || !s.IsPast || s.Missed {
+					t.Errorf("once = %+v", s)
+				}
+				if s := got["daily"]; s.IsRunning || !s.HasError || s.IsPast || !s.Time.After(start) {
+					t.Errorf("daily = %+v", s)
+				}
+			},
+		},
+		{
+			name:    "skip",
+			catchUp: CatchUpSkip,
+			stored: func(now time.Time) []Schedule {
+				return []Schedule{
+					{ID: "once", Time: now.Add(-time.Hour)},
+					{ID: "daily", Time: now.Add(-time.Hour), Recurrence: daily()},
+				}
+			},
+			check: func(t *testing.T, start time.Time, got map[string]*Schedule, results []JobResult) {
+				if s := got["once"]; !s.Missed || !s.IsPast || !s.HasError || s.MissedTime != nil {
+					t.Errorf("once = %+v", s)
+				}
+				// a recurring schedule waits for its next fire time
+				if s := got["daily"]; !s.Missed || s.IsPast || !s.HasError || !s.Time.After(start) || !s.catchUpTime.IsZero() {
+					t.Errorf("daily = %+v", s)
+				}
+				if len(results) != 2 {
+					t.Fatalf("results = %+v, want 2", results)
+				}
+				for _, res := range results {
+					if !strings.HasPrefix(res.Error, "Job missed") || res.RunType != Scheduled {
+						t.Errorf("result = %+v", res)
+					}
+				}
+			},
+		},
+		{
+			name:    "run",
+			catchUp: CatchUpRun,
+			stored: func(now time.Time) []Schedule {
+				return []Schedule{
+					{ID: "first", Time: now.Add(-2 * time.Hour)},
+					{ID: "second", Time: now.Add(-time.Hour)},
+					{ID: "daily", Time: now.Add(-30 * time.Minute), Recurrence: daily()},
+					// a conflicting schedule does not move a run catch-up
+					{ID: "upcoming", Time: now.Add(time.Minute)},
+				}
+			},
+			check: func(t *testing.T, start time.Time, got map[string]*Schedule, results []JobResult) {
+				// missed runs go one after another, a conflict window apart, oldest first
+				if s := got["first"]; !s.Missed || s.IsPast || s.MissedTime == nil || !slotAfter(s.Time, start, 0) {
+					t.Errorf("first = %+v", s)
+				}
+				if s := got["second"]; !s.Missed || s.MissedTime == nil || !slotAfter(s.Time, start, scheduleConflictWindow) {
+					t.Errorf("second = %+v", s)
+				}
+				// the recurring schedule keeps its fire times and catches up with an extra run
+				if s := got["daily"]; !s.Missed || s.MissedTime == nil || !s.Time.After(start) || !slotAfter(s.catchUpTime, start, 2*scheduleConflictWindow) {
+					t.Errorf("daily = %+v", s)
+				}
+				if len(results) != 0 {
+					t.Errorf("results = %+v", results)
+				}
+			},
+		},
+		{
+			name:    "next slot",
+			catchUp: CatchUpNextSlot,
+			stored: func(now time.Time) []Schedule {
+				return []Schedule{
+					{ID: "missed", Time: now.Add(-time.Hour)},
+					{ID: "upcoming", Time: now.Add(time.Minute)},
+				}
+			},
+			check: func(t *testing.T, start time.Time, got map[string]*Schedule, results []JobResult) {
+				// the slots within the conflict window of the upcoming schedule are passed over
+				if s := got["missed"]; !s.Missed || s.MissedTime == nil || !slotAfter(s.Time, start, 2*scheduleConflictWindow) {
+					t.Errorf("missed = %+v", s)
+				}
+				if len(results) != 0 {
+					t.Errorf("results = %+v", results)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &AppConfig{File: FileConfig{Schedules: ScheduleConfig{CatchUp: tt.catchUp}}}
+			app, err := NewApp(config, NewMemoryStore())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer app.scheduler.Shutdown()
+
+			start := time.Now()
+			for _, sched := range tt.stored(start) {
+				if err := app.Store.SaveSchedule(sched); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := app.RestoreSchedules(); err != nil {
+				t.Fatalf("RestoreSchedules() error = %v", err)
+			}
+
+			results, _, err := app.Store.ListResults(JobFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.check(t, start, app.schedules, results)
+
+			// what was decided is stored for the next restart
+			stored, err := app.Store.ListSchedules()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range stored {
+				if mem := app.schedules[s.ID]; s.Missed != mem.Missed || s.IsPast != mem.IsPast || !s.Time.Equal(mem.Time) {
+					t.Errorf("stored %+v, in memory %+v", s, *mem)
+				}
+			}
+		})
+	}
+}
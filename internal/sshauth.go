@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshAgent is a shared connection to the SSH agent at SSH_AUTH_SOCK, dialed on first use.
+type sshAgent struct {
+	mutex  sync.Mutex
+	conn   net.Conn
+	client agent.ExtendedAgent
+}
+
+// Signers returns the agent's keys, redialing the agent when the previous connection broke.
+func (a *sshAgent) Signers() ([]ssh.Signer, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.client != nil {
+		if signers, err := a.client.Signers(); err == nil {
+			return signers, nil
+		}
+		a.conn.Close()
+		a.client = nil
+	}
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("ssh agent: SSH_AUTH_SOCK not set")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("ssh agent: %w", err)
+	}
+
+	a.conn = conn
+	a.client = agent.NewClient(conn)
+
+	return a.client.Signers()
+}
+
+// sshAuthMethods builds the auth methods of a host in its configured order. The key file and the
+// agent share one publickey method, because the SSH client tries each method type only once.
+func (app *App) sshAuthMethods(host HostEntry, creds HostSSHConfig) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	var keySources []func() ([]ssh.Signer, error)
+
+	for _, method := range host.Auth {
+		switch method {
+		case AuthPassword:
+			methods = append(methods, ssh.Password(creds.Pass))
+
+		case AuthKeyboardInteractive:
+			methods = append(methods, ssh.KeyboardInteractive(passwordChallenge(creds.Pass)))
+
+		case AuthPublicKey:
+			if len(keySources) == 0 {
+				methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+					return collectSigners(keySources)
+				}))
+			}
+			keySources = append(keySources, func() ([]ssh.Signer, error) {
+				return loadKeyFile(host.KeyFile, creds.KeyPassphrase)
+			})
+
+		case AuthAgent:
+			if len(keySources) == 0 {
+				methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+					return collectSigners(keySources)
+				}))
+			}
+			keySources = append(keySources, app.agent.Signers)
+		}
+	}
+
+	return methods
+}
+
+// collectSigners returns the keys of every source in order. A source that fails is skipped as
+// long as another one provides keys.
+func collectSigners(sources []func() ([]ssh.Signer, error)) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	var firstErr error
+
+	for _, source := range sources {
+		keys, err := source()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		signers = append(signers, keys...)
+	}
+
+	if len(signers) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+
+	return signers, nil
+}
+
+// loadKeyFile reads a private key, decrypting it with passphrase when it is protected.
+func loadKeyFile(path, passphrase string) ([]ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key_file: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	if _, missing := err.(*ssh.PassphraseMissingError); missing {
+		if passphrase == "" {
+			return nil, fmt.Errorf("key_file %s is encrypted and no passphrase is set", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key_file %s: %w", path, err)
+	}
+
+	return []ssh.Signer{signer}, nil
+}
+
+// passwordChallenge answers every hidden keyboard-interactive prompt with the password.
+func passwordChallenge(pass string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			if !echos[i] {
+				answers[i] = pass
+			}
+		}
+		return answers, nil
+	}
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeKeyFile writes a new ed25519 private key, encrypted when passphrase is set, and returns its
+// path and public key.
+func writeKeyFile(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, sshPub
+}
+
+// startTestAgent serves an agent holding keys at a new SSH_AUTH_SOCK.
+func startTestAgent(t *testing.T, keys ...ed25519.PrivateKey) {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+}
+
+func TestSSHAuthMethods(t *testing.T) {
+	keyFile, accepted := writeKeyFile(t, "")
+	encryptedKeyFile, acceptedEncrypted := writeKeyFile(t, "hunter2")
+
+	_, agentKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server accepts the password "secret" (also as the keyboard-interactive answer) and the
+	// two key files, but not the agent's key
+	serverConfig := func() *ssh.ServerConfig {
+		return &ssh.ServerConfig{
+			PasswordCallback: func(_ ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+				if string(pass) == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("wrong password")
+			},
+			KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+				answers, err := client("", "", []string{"Password: "}, []bool{false})
+				if err == nil && len(answers) == 1 && answers[0] == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("wrong answer")
+			},
+			PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				if bytes.Equal(key.Marshal(), accepted.Marshal()) || bytes.Equal(key.Marshal(), acceptedEncrypted.Marshal()) {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("unknown key")
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		auth      []string
+		keyFile   string
+		creds     HostSSHConfig
+		agent     bool // serve the agent key at SSH_AUTH_SOCK
+		wantAuths string
+		wantErr   string
+	}{
+		{name: "password", auth: []string{AuthPassword}, creds: HostSSHConfig{Pass: "secret"}, wantAuths: "[password ok]"},
+		{name: "wrong password", auth: []string{AuthPassword}, creds: HostSSHConfig{Pass: "nope"}, wantAuths: "[password failed]", wantErr: "unable to authenticate"},
+		{
+			name: "password before publickey", auth: []string{AuthPassword, AuthPublicKey}, keyFile: keyFile,
+			creds: HostSSHConfig{Pass: "nope"}, wantAuths: "[password failed publickey ok]",
+		},
+		{
+			name: "publickey before password", auth: []string{AuthPublicKey, AuthPassword}, keyFile: keyFile,
+			creds: HostSSHConfig{Pass: "secret"}, wantAuths: "[publickey ok]",
+		},
+		{name: "keyboard-interactive", auth: []string{AuthKeyboardInteractive}, creds: HostSSHConfig{Pass: "secret"}, wantAuths: "[keyboard-interactive ok]"},
+		{
+			name: "agent key first", auth: []string{AuthAgent, AuthPublicKey}, keyFile: keyFile, agent: true,
+			wantAuths: "[publickey failed publickey ok]",
+		},
+		{name: "agent without SSH_AUTH_SOCK", auth: []string{AuthAgent, AuthPublicKey}, keyFile: keyFile, wantAuths: "[publickey ok]"},
+		{
+			name: "encrypted key file", auth: []string{AuthPublicKey}, keyFile: encryptedKeyFile,
+			creds: HostSSHConfig{KeyPassphrase: "hunter2"}, wantAuths: "[publickey ok]",
+		},
+		{name: "encrypted key file without passphrase", auth: []string{AuthPublicKey}, keyFile: encryptedKeyFile, wantErr: "no passphrase is set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.agent {
+				startTestAgent(t, agentKey)
+			} else {
+				t.Setenv("SSH_AUTH_SOCK", "")
+			}
+
+			srv := newTestSSHServer(t, serverConfig(), newEd25519Signer(t))
+			app := &App{}
+
+			target := srv.target("sdvn")
+			target.Auth = app.sshAuthMethods(HostEntry{Auth: tt.auth, KeyFile: tt.keyFile}, tt.creds)
+
+			client, err := connectSSH(context.Background(), target, (&net.Dialer{}).DialContext)
+			if err == nil {
+				client.Close()
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("connectSSH() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("connectSSH() error = %v, want %q", err, tt.wantErr)
+			}
+
+			srv.mutex.Lock()
+			defer srv.mutex.Unlock()
+			if tt.wantAuths != "" && fmt.Sprint(srv.auths) != tt.wantAuths {
+				t.Errorf("auth attempts = %v, want %v", srv.auths, tt.wantAuths)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshClient is an SSH connection opened by dialSSH. While it is open, keepalive requests check the
+// peer is still alive; when too many go unanswered the connection is closed and Err reports why.
+type sshClient struct {
+	*ssh.Client
+	label string
+	hops  []*sshClient // jump host connections the client is tunneled through, first hop first
+
+	mutex sync.Mutex
+	err   error // set when the keepalive gave up on the connection
+}
+
+// dialFunc opens the transport connection of the next hop.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialSSH connects and authenticates to target within its connect and handshake timeouts, and
+// starts its keepalives. When the target has jump hosts, each hop is connected the same way and the
+// next connection is tunneled through it. Canceling ctx aborts a connection that is still being set up.
+func dialSSH(ctx context.Context, target SSHJobTarget) (*sshClient, error) {
+	var hops []*sshClient
+
+	closeHops := func() {
+		for i := len(hops) - 1; i >= 0; i-- {
+			hops[i].Close()
+		}
+	}
+
+	dial := (&net.Dialer{}).DialContext
+
+	for _, hop := range target.Jumps {
+		client, err := connectSSH(ctx, hop, dial)
+		if err != nil {
+			closeHops()
+			return nil, fmt.Errorf("jump host %s: %w", hop.Label, err)
+		}
+
+		hops = append(hops, client)
+		dial = client.DialContext
+	}
+
+	client, err := connectSSH(ctx, target, dial)
+	if err != nil {
+		closeHops()
+		return nil, err
+	}
+	client.hops = hops
+
+	return client, nil
+}
+
+// connectSSH opens one SSH connection over a transport from dial.
+func connectSSH(ctx context.Context, target SSHJobTarget, dial dialFunc) (*sshClient, error) {
+	opts := target.Options
+	addr := target.Addr()
+
+	config := &ssh.ClientConfig{
+		User:              target.User,
+		Auth:              target.Auth,
+		HostKeyCallback:   target.HostKey,
+		HostKeyAlgorithms: target.HostKeyAlgorithms,
+	}
+	config.Ciphers = opts.Ciphers
+	config.KeyExchanges = opts.KexAlgorithms
+
+	dialCtx, cancel := context.WithTimeout(ctx, opts.ConnectTimeout)
+	conn, err := dial(dialCtx, "tcp", addr)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	c, chans, reqs, err := handshake(ctx, conn, addr, config, opts.HandshakeTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client := &sshClient{Client: ssh.NewClient(c, chans, reqs), label: target.Label}
+
+	if opts.KeepaliveInterval > 0 {
+		go client.keepalive(opts.KeepaliveInterval, opts.KeepaliveMaxMissed)
+	}
+
+	return client, nil
+}
+
+// Close closes the connection and then its jump host connections.
+func (c *sshClient) Close() error {
+	err := c.Client.Close()
+
+	for i := len(c.hops) - 1; i >= 0; i-- {
+		c.hops[i].Close()
+	}
+
+	return err
+}
+
+// validateAlgorithms checks that every configured cipher and KEX algorithm is implemented by the
+// SSH library (including the legacy ones it only offers when asked for explicitly).
+func validateAlgorithms(opts HostOptions) error {
+	supported, legacy := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+
+	for _, cipher := range opts.Ciphers {
+		if !slices.Contains(supported.Ciphers, cipher) && !slices.Contains(legacy.Ciphers, cipher) {
+			return fmt.Errorf("unsupported cipher %q", cipher)
+		}
+	}
+	for _, kex := range opts.KexAlgorithms {
+		if !slices.Contains(supported.KeyExchanges, kex) && !slices.Contains(legacy.KeyExchanges, kex) {
+			return fmt.Errorf("unsupported kex algorithm %q", kex)
+		}
+	}
+
+	return nil
+}
+
+// handshake runs the SSH handshake on conn, giving up after timeout or when ctx is canceled.
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig, timeout time.Duration) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, nil, ctx.Err()
+		}
+		return nil, nil, nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return c, chans, reqs, nil
+}
+
+// keepalive sends a keepalive request every interval until the connection closes, and closes the
+// connection after maxMissed requests in a row got no reply.
+func (c *sshClient) keepalive(interval time.Duration, maxMissed int) {
+	closed := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case <-closed:
+			return
+		case err := <-reply:
+			if err == nil {
+				missed = 0
+				continue
+			}
+			missed++
+		case <-time.After(interval):
+			missed++
+		}
+
+		if missed >= maxMissed {
+			c.mutex.Lock()
+			c.err = fmt.Errorf("connection to %s lost: no keepalive reply for %s", c.label, time.Duration(missed)*interval)
+			c.mutex.Unlock()
+
+			slog.Error("closing dead SSH connection", "host", c.label, "missed", missed)
+			c.Close()
+			return
+		}
+	}
+}
+
+// Err returns why the keepalive closed the connection or one of its jump host connections, or nil.
+func (c *sshClient) Err() error {
+	for _, hop := range c.hops {
+		if err := hop.Err(); err != nil {
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
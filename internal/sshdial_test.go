@@ -0,0 +1,577 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is an in-process SSH server. Exec requests run exec, the sftp subsystem serves the
+// local file system, and direct-tcpip channels (as opened for a jump host) are connected to their
+// destination.
+type testSSHServer struct {
+	addr string
+	exec func(cmd string, stdout, stderr io.Writer) int
+
+	mutex    sync.Mutex
+	auths    []string // auth methods tried, in order, with their outcome
+	commands []string
+	tunnels  []string
+}
+
+// newTestSSHServer starts a server with the host keys; a nil config accepts every client.
+func newTestSSHServer(t *testing.T, config *ssh.ServerConfig, hostKeys ...ssh.Signer) *testSSHServer {
+	t.Helper()
+
+	if config == nil {
+		config = &ssh.ServerConfig{NoClientAuth: true}
+	}
+	for _, key := range hostKeys {
+		config.AddHostKey(key)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	srv := &testSSHServer{addr: ln.Addr().String()}
+	srv.exec = func(cmd string, stdout, stderr io.Writer) int {
+		fmt.Fprintf(stdout, "ran %s\n", cmd)
+		return 0
+	}
+
+	config.AuthLogCallback = func(_ ssh.ConnMetadata, method string, err error) {
+		srv.mutex.Lock()
+		defer srv.mutex.Unlock()
+
+		if err == nil {
+			srv.auths = append(srv.auths, method+" ok")
+		} else if method != "none" {
+			srv.auths = append(srv.auths, method+" failed")
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn, config)
+		}
+	}()
+
+	return srv
+}
+
+func (srv *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "session":
+			go srv.session(newChan)
+		case "direct-tcpip":
+			go srv.tunnel(newChan)
+		default:
+			newChan.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (srv *testSSHServer) session(newChan ssh.NewChannel) {
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		if req.Type == "subsystem" && string(req.Payload[4:]) == "sftp" {
+			req.Reply(true, nil)
+			if server, err := sftp.NewServer(ch); err == nil {
+				server.Serve()
+			}
+			return
+		}
+		if req.Type != "exec" {
+			req.Reply(req.Type == "env" || req.Type == "pty-req", nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+
+		srv.mutex.Lock()
+		srv.commands = append(srv.commands, payload.Command)
+		srv.mutex.Unlock()
+
+		status := srv.exec(payload.Command, ch, ch.Stderr())
+		ch.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, uint32(status)))
+		return
+	}
+}
+
+func (srv *testSSHServer) tunnel(newChan ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	dest := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	conn, err := net.Dial("tcp", dest)
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	srv.mutex.Lock()
+	srv.tunnels = append(srv.tunnels, dest)
+	srv.mutex.Unlock()
+
+	go func() {
+		io.Copy(conn, ch)
+		conn.Close()
+	}()
+	io.Copy(ch, conn)
+	ch.Close()
+}
+
+// target returns an SSH target for the server that accepts any host key.
+func (srv *testSSHServer) target(label string) SSHJobTarget {
+	host, port, _ := net.SplitHostPort(srv.addr)
+	n, _ := strconv.Atoi(port)
+
+	return SSHJobTarget{
+		Label:   label,
+		IP:      host,
+		Port:    n,
+		Options: HostOptions{ConnectTimeout: 5 * time.Second, HandshakeTimeout: 5 * time.Second},
+		HostKey: ssh.InsecureIgnoreHostKey(),
+		User:    "routetest",
+	}
+}
+
+func newEd25519Signer(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newECDSASigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// writeKnownHosts writes a known_hosts file with one line per key for addr.
+func writeKnownHosts(t *testing.T, addr string, keys ...ssh.PublicKey) string {
+	t.Helper()
+
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, knownhosts.Line([]string{knownhosts.Normalize(addr)}, key))
+	}
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConnectSSHHostKeyAlgorithms(t *testing.T) {
+	ecdsaKey, ed25519Key := newECDSASigner(t), newEd25519Signer(t)
+
+	// the server has an ECDSA key too, which the client would otherwise ask for first
+	srv := newTestSSHServer(t, nil, ecdsaKey, ed25519Key)
+	checker := &hostKeyChecker{knownHostsPath: writeKnownHosts(t, srv.addr, ed25519Key.PublicKey())}
+
+	target := srv.target("sdvn")
+	target.HostKey = checker.Callback("sdvn", nil)
+	target.HostKeyAlgorithms = checker.Algorithms(target.Addr(), nil)
+
+	if want := []string{ssh.KeyAlgoED25519}; fmt.Sprint(target.HostKeyAlgorithms) != fmt.Sprint(want) {
+		t.Fatalf("HostKeyAlgorithms = %v, want %v", target.HostKeyAlgorithms, want)
+	}
+
+	client, err := connectSSH(context.Background(), target, (&net.Dialer{}).DialContext)
+	if err != nil {
+		t.Fatalf("connectSSH() error = %v", err)
+	}
+	client.Close()
+
+	target.HostKeyAlgorithms = nil
+	if client, err := connectSSH(context.Background(), target, (&net.Dialer{}).DialContext); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		if client != nil {
+			client.Close()
+		}
+		t.Errorf("connectSSH() offering every algorithm: error = %v, want a host key mismatch", err)
+	}
+}
+
+func TestConnectSSHTimeouts(t *testing.T) {
+	srv := newTestSSHServer(t, nil, newEd25519Signer(t))
+
+	// a listener that accepts connections but never speaks SSH
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	hang := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	tests := []struct {
+		name    string
+		addr    string
+		dial    dialFunc
+		cancel  time.Duration // cancel the context after this long
+		wantErr error
+		want    string
+	}{
+		{name: "connected", addr: srv.addr},
+		{name: "connect timeout", addr: srv.addr, dial: hang, wantErr: context.DeadlineExceeded},
+		{name: "handshake timeout", addr: silent.Addr().String(), want: "i/o timeout"},
+		{name: "canceled handshake", addr: silent.Addr().String(), cancel: 50 * time.Millisecond, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := srv.target("sdvn")
+			host, port, _ := net.SplitHostPort(tt.addr)
+			target.IP = host
+			target.Port, _ = strconv.Atoi(port)
+			target.Options = HostOptions{ConnectTimeout: 200 * time.Millisecond, HandshakeTimeout: 200 * time.Millisecond}
+			if tt.cancel > 0 {
+				target.Options.HandshakeTimeout = 5 * time.Second
+			}
+
+			dial := tt.dial
+			if dial == nil {
+				dial = (&net.Dialer{}).DialContext
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+
+			start := time.Now()
+			client, err := connectSSH(ctx, target, dial)
+			if client != nil {
+				client.Close()
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("connectSSH() took %s", elapsed)
+			}
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("connectSSH() error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.want != "":
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("connectSSH() error = %v, want %q", err, tt.want)
+				}
+			case err != nil:
+				t.Errorf("connectSSH() error = %v", err)
+			}
+		})
+	}
+}
+
+// stallProxy forwards connections to addr until stalled, and then drops what either side sends.
+type stallProxy struct {
+	net.Listener
+	stalled atomic.Bool
+}
+
+func newStallProxy(t *testing.T, addr string) *stallProxy {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	p := &stallProxy{Listener: ln}
+	forward := func(dst, src net.Conn) {
+		defer dst.Close()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if p.stalled.Load() {
+				continue
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go forward(upstream, conn)
+			go forward(conn, upstream)
+		}
+	}()
+
+	return p
+}
+
+func TestSSHKeepalive(t *testing.T) {
+	srv := newTestSSHServer(t, nil, newEd25519Signer(t))
+	proxy := newStallProxy(t, srv.addr)
+
+	target := srv.target("sdvn")
+	_, port, _ := net.SplitHostPort(proxy.Addr().String())
+	target.Port, _ = strconv.Atoi(port)
+	target.Options.KeepaliveInterval = 20 * time.Millisecond
+	target.Options.KeepaliveMaxMissed = 3
+
+	client, err := connectSSH(context.Background(), target, (&net.Dialer{}).DialContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// answered keepalives keep the connection open
+	time.Sleep(100 * time.Millisecond)
+	if err := client.Err(); err != nil {
+		t.Fatalf("Err() on a live connection = %v", err)
+	}
+
+	proxy.stalled.Store(true)
+
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed after the keepalives went unanswered")
+	}
+	if err := client.Err(); err == nil || !strings.Contains(err.Error(), "connection to sdvn lost") {
+		t.Errorf("Err() = %v, want a lost connection", err)
+	}
+}
+
+func TestDialSSHProxyJump(t *testing.T) {
+	outer := newTestSSHServer(t, nil, newEd25519Signer(t))
+	bastion := newTestSSHServer(t, nil, newEd25519Signer(t))
+	router := newTestSSHServer(t, nil, newEd25519Signer(t))
+	locked := newTestSSHServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("wrong password")
+		},
+	}, newEd25519Signer(t))
+
+	// a jump host nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := &testSSHServer{addr: ln.Addr().String()}
+	ln.Close()
+
+	tests := []struct {
+		name        string
+		target      *testSSHServer
+		jumps       []*testSSHServer
+		wantTunnels map[*testSSHServer][]string
+		wantErr     string
+	}{
+		{
+			name:        "one hop",
+			target:      router,
+			jumps:       []*testSSHServer{bastion},
+			wantTunnels: map[*testSSHServer][]string{bastion: {router.addr}},
+		},
+		{
+			name:        "two hops",
+			target:      router,
+			jumps:       []*testSSHServer{outer, bastion},
+			wantTunnels: map[*testSSHServer][]string{outer: {bastion.addr}, bastion: {router.addr}},
+		},
+		{name: "jump host down", target: router, jumps: []*testSSHServer{down}, wantErr: "jump host jump0: "},
+		{name: "second jump host down", target: router, jumps: []*testSSHServer{bastion, down}, wantErr: "jump host jump1: "},
+		{name: "target refuses auth", target: locked, jumps: []*testSSHServer{bastion}, wantErr: "unable to authenticate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, srv := range []*testSSHServer{outer, bastion, router, locked} {
+				srv.mutex.Lock()
+				srv.tunnels = nil
+				srv.mutex.Unlock()
+			}
+
+			target := tt.target.target("router")
+			for i, jump := range tt.jumps {
+				hop := jump.target(fmt.Sprintf("jump%d", i))
+				hop.Options.ConnectTimeout = time.Second
+				target.Jumps = append(target.Jumps, hop)
+			}
+
+			client, err := dialSSH(context.Background(), target)
+			if tt.wantErr != "" {
+				if client != nil {
+					client.Close()
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dialSSH() error = %v, want %q", err, tt.wantErr)
+				}
+				if strings.HasPrefix(tt.wantErr, "jump host") != strings.HasPrefix(err.Error(), "jump host") {
+					t.Errorf("dialSSH() error = %v: wrong hop blamed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dialSSH() error = %v", err)
+			}
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := session.Output("take 6")
+			session.Close()
+			if err != nil || string(out) != "ran take 6\n" {
+				t.Fatalf("Output() = %q, %v", out, err)
+			}
+
+			for srv, want := range tt.wantTunnels {
+				srv.mutex.Lock()
+				got := fmt.Sprint(srv.tunnels)
+				srv.mutex.Unlock()
+				if got != fmt.Sprint(want) {
+					t.Errorf("tunnels through %s = %v, want %v", srv.addr, got, want)
+				}
+			}
+			if len(client.hops) != len(tt.jumps) {
+				t.Errorf("client has %d hops, want %d", len(client.hops), len(tt.jumps))
+			}
+		})
+	}
+}
+
+func TestSSHTargetProxyJump(t *testing.T) {
+	app := &App{
+		Config: &AppConfig{File: FileConfig{Hosts: map[string]HostEntry{
+			"outer":   {Name: "outer", Address: "10.9.0.1", Port: 22, Auth: []string{AuthPassword}},
+			"bastion": {Name: "bastion", Address: "10.9.0.2", Port: 2222, Auth: []string{AuthPassword}},
+			"router":  {Name: "router", Address: "10.9.4.21", Port: 22, Auth: []string{AuthPassword}, ProxyJump: []string{"outer", "bastion"}},
+		}}, Credentials: map[string]HostSSHConfig{
+			"bastion": {User: "jump"},
+			"router":  {User: "routetest"},
+		}},
+		hostKeys: &hostKeyChecker{knownHostsPath: filepath.Join(t.TempDir(), "known_hosts")},
+	}
+
+	target := app.sshTarget("router")
+
+	var hops []string
+	for _, hop := range target.Jumps {
+		hops = append(hops, fmt.Sprintf("%s %s@%s", hop.Label, hop.User, hop.Addr()))
+	}
+	if want := "[outer @10.9.0.1:22 bastion jump@10.9.0.2:2222]"; fmt.Sprint(hops) != want {
+		t.Errorf("jumps = %v, want %v", hops, want)
+	}
+	if target.Addr() != "10.9.4.21:22" || target.User != "routetest" {
+		t.Errorf("target = %s@%s", target.User, target.Addr())
+	}
+}
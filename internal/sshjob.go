@@ -1,31 +1,59 @@
 package internal
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
 )
 
 type SSHJobTarget struct {
-	Label    string // e.g. "scheduler" or "sdvn"
+	Label    string // host name, e.g. "scheduler" or "sdvn"
 	IP       string
+	Port     int
+	Options  HostOptions    // timeouts, keepalives and algorithms
+	Jumps    []SSHJobTarget // jump hosts to tunnel through, first hop first
+	HostKey  ssh.HostKeyCallback
 	User     string
-	Pass     string
-	Commands []string
+	Auth     []ssh.AuthMethod // in the host's configured order
+	Commands []CommandConfig
 	Command  string
+
+	// Host key algorithms offered to the server, those of the keys known_hosts records for the
+	// host; nil offers every algorithm
+	HostKeyAlgorithms []string
+
+	// Files to upload before the commands, the directory they run in and the remote files to
+	// download over SFTP once they are done
+	Uploads   []UploadConfig
+	WorkDir   string
+	Artifacts []string
+
+	// Test window as of the stage start, passed to the commands and used to skip stale artifacts
+	Window TestWindow
+
+	// Output capture for persistent commands; nil discards the output
+	Capture *cappedBuffer
+}
+
+// Addr returns the host:port to dial.
+func (t SSHJobTarget) Addr() string {
+	return net.JoinHostPort(t.IP, strconv.Itoa(t.Port))
 }
 
 // SSHPersistentHandle represents a long-lived remote SSH command/process.
 type SSHPersistentHandle struct {
 	Session    *ssh.Session
-	Connection *ssh.Client
+	Connection *sshClient
 	Label      string // for activity/status/reporting, e.g., "scheduler"
 	Cmd        string
+	Output     *cappedBuffer // captured stdout/stderr, nil when not captured
 	once       sync.Once
 	closed     bool
 }
@@ -49,42 +77,57 @@ func (h *SSHPersistentHandle) Close() error {
 	return err // always returns nil for now; can be extended to aggregate errors
 }
 
+// Stop terminates the remote command and closes its session but keeps the SSH connection, e.g. to
+// collect artifacts before Close.
+func (h *SSHPersistentHandle) Stop() {
+	if h.Session != nil {
+		_ = h.Session.Signal(ssh.SIGKILL)
+		_ = h.Session.Close()
+	}
+}
+
 // sshRunPersistentCmd establishes an SSH connection to the given host, starts the command (non-blocking),
 // and returns a handle that allows the caller to Close() when finished.
-// Stdout and Stderr should be attached as needed (may be the caller's responsibility for real streaming).
+// When target.Capture is set, Stdout and Stderr are collected into it (within its limits) and streamed
+// as output events; otherwise the output is discarded.
+// Like sshRunCmd, the command runs in the host's working directory after its uploads are copied
+// there; the upload log is returned for the stage output.
 // Activity status is updated before/after all major phases.
-// Errors on connect or start prevent the handle from being returned.
-func sshRunPersistentCmd(ctx context.Context, app *App, target SSHJobTarget) (*SSHPersistentHandle, error) {
+// Errors on connect, upload or start prevent the handle from being returned.
+func sshRunPersistentCmd(ctx context.Context, app *App, target SSHJobTarget) (*SSHPersistentHandle, string, error) {
 	app.SetJobActivity(fmt.Sprintf("Connecting to %s (%s) via SSH (persistent)...", target.Label, target.IP))
-	config := &ssh.ClientConfig{
-		User: target.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(target.Pass),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+	conn, err := dialSSH(ctx, target)
+	if err != nil {
+		return nil, "", fmt.Errorf("SSH connect failed: %w", err)
 	}
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", target.IP), config)
+
+	var uploads strings.Builder
+	workDir, err := app.prepareWorkDir(ctx, conn, target, &uploads)
 	if err != nil {
-		return nil, fmt.Errorf("SSH connect failed: %w", err)
+		conn.Close()
+		if err == context.Canceled {
+			return nil, uploads.String(), fmt.Errorf("job stopped by user")
+		}
+		return nil, uploads.String(), err
 	}
 
 	app.SetJobActivity(fmt.Sprintf("Starting persistent command on %s (%s):\n%s", target.Label, target.IP, target.Command))
 	session, err := conn.NewSession()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("session create failed: %w", err)
+		return nil, uploads.String(), fmt.Errorf("session create failed: %w", err)
 	}
 
-	// The caller should optionally set up Stdout, Stderr, Stdin here if needed (streaming etc)
-	// session.Stdout = ...
-	// session.Stderr = ...
+	if target.Capture != nil {
+		session.Stdout = io.MultiWriter(target.Capture, app.newLineWriter(target.Label, 0, "stdout"))
+		session.Stderr = io.MultiWriter(target.Capture, app.newLineWriter(target.Label, 0, "stderr"))
+	}
 
-	err = session.Start(target.Command)
+	err = session.Start(inDir(workDir, target.Command))
 	if err != nil {
 		session.Close()
 		conn.Close()
-		return nil, fmt.Errorf("failed to start persistent remote command: %w", err)
+		return nil, uploads.String(), fmt.Errorf("failed to start persistent remote command: %w", err)
 	}
 
 	// The caller must call handle.Close() to release resources and kill the remote process/session.
@@ -93,92 +136,116 @@ func sshRunPersistentCmd(ctx context.Context, app *App, target SSHJobTarget) (*S
 		Connection: conn,
 		Label:      target.Label,
 		Cmd:        target.Command,
-	}, nil
+		Output:     target.Capture,
+	}, uploads.String(), nil
 }
 
 // sshRunCmd executes one or more shell commands on a remote host via SSH,
 // updating the app's job activity status for each phase. For every command in target.Commands,
 // it opens a new SSH session, updates activity, and runs the command, appending the full output
 // (stdout and stderr) to a combined result string.
-// If any command fails or if the provided context is canceled (such as by a user-initiated stop),
+// Each command runs under its timeout/retries/allow_failure policy (see runCommandPolicy), in the
+// host's working directory after its uploads are copied there (see prepareWorkDir); the host's
+// artifacts are collected once the commands are done.
+// If a command still fails or if the provided context is canceled (such as by a user-initiated stop),
 // execution halts immediately: the current SSH session is closed, partial output is returned,
 // and an error is propagated upstream.
 // This function ensures thread-safe setting and clearing of the app's active session pointer
 // for robust interruption and status UX feedback.
-// Returns the complete aggregated output for all completed commands and an error if the job was stopped
-// or a command failed.
-func sshRunCmd(ctx context.Context, app *App, target SSHJobTarget) (string, error) {
+// Returns the complete aggregated output for all completed commands, a CommandResult per attempt and
+// an error if the job was stopped or a command failed.
+func sshRunCmd(ctx context.Context, app *App, target SSHJobTarget) (string, []CommandResult, error) {
 	app.SetJobActivity(fmt.Sprintf("Connecting to %s (%s) via SSH...", target.Label, target.IP))
-	config := &ssh.ClientConfig{
-		User: target.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(target.Pass),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+	conn, err := dialSSH(ctx, target)
+	if err != nil {
+		return "", nil, err
 	}
+	defer conn.Close()
+
+	var log commandLog
 
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", target.IP), config)
+	workDir, err := app.prepareWorkDir(ctx, conn, target, &log.output)
+	if err == context.Canceled {
+		return log.output.String(), nil, fmt.Errorf("job stopped by user")
+	}
 	if err != nil {
-		return "", err
+		return log.output.String(), nil, err
 	}
-	defer conn.Close()
 
-	var combinedOutput strings.Builder
+	target.WorkDir = workDir
 
 	for i, cmd := range target.Commands {
-		app.SetJobActivity(fmt.Sprintf(
-			"Running command %d/%d on %s (%s):\n%s",
-			i+1, len(target.Commands), target.Label, target.IP, cmd,
-		))
-
-		session, err := conn.NewSession()
+		where := fmt.Sprintf("command %d/%d on %s (%s)", i+1, len(target.Commands), target.Label, target.IP)
+		app.SetJobActivity(fmt.Sprintf("Running %s:\n%s", where, cmd.Run))
+
+		err := app.runCommandPolicy(ctx, cmd, where, &log, func(ctx context.Context) (*outputBuffer, *outputBuffer, error) {
+			return sshRunAttempt(ctx, app, conn, target.Label, i+1, app.sshCommandLine(target, cmd.Run))
+		})
+		if err == context.Canceled {
+			return log.output.String(), log.results, fmt.Errorf("job stopped by user")
+		}
 		if err != nil {
-			combinedOutput.WriteString(fmt.Sprintf("Failed to create session for command %d: %v\n", i+1, err))
-			return combinedOutput.String(), err
+			app.collectArtifacts(ctx, conn, target, &log.output)
+			return log.output.String(), log.results, err
 		}
+	}
 
-		app.setActiveSession(session) // <-- new helper, thread-safe field set
-
-		var outBuf, errBuf bytes.Buffer
+	app.collectArtifacts(ctx, conn, target, &log.output)
 
-		session.Stdout = &outBuf
-		session.Stderr = &errBuf
+	return log.output.String(), log.results, nil
+}
 
-		done := make(chan struct{})
-		var runErr error
+// sshRunAttempt runs one command in a new session of conn and returns its stdout and stderr, kept
+// within the job's output limits.
+// When ctx ends first (job stopped or command timed out) the remote command is killed.
+func sshRunAttempt(ctx context.Context, app *App, conn *sshClient, label string, index int, cmd string) (*outputBuffer, *outputBuffer, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
 
-		// run the command in the background
-		go func() {
-			defer close(done)
-			runErr = session.Run(cmd)
-		}()
+	app.setActiveSession(session)
+	defer app.clearActiveSession()
 
-		// check if the stop button is clicked / done channel is closed
-		select {
-		case <-ctx.Done():
-			// Job was canceled by user
-			<-done // wait for the run goroutine to finish
+	outBuf := app.newOutputBuffer(label, index, "stdout")
+	errBuf := app.newOutputBuffer(label, index, "stderr")
+	outLines := app.newLineWriter(label, index, "stdout")
+	errLines := app.newLineWriter(label, index, "stderr")
 
-			combinedOutput.WriteString(fmt.Sprintf("[CANCELED] Command: %s\nOutput:\n%s%s\n", cmd, outBuf.String(), errBuf.String()))
-			app.clearActiveSession()
+	session.Stdout = io.MultiWriter(outBuf, outLines)
+	session.Stderr = io.MultiWriter(errBuf, errLines)
 
-			return combinedOutput.String(), fmt.Errorf("job stopped by user")
+	done := make(chan struct{})
+	var runErr error
 
-		case <-done:
-			app.clearActiveSession()
+	// run the command in the background
+	go func() {
+		defer close(done)
+		runErr = session.Run(cmd)
+	}()
 
-			combinedOutput.WriteString(fmt.Sprintf("Command: %s\nOutput:\n%s%s\n", cmd, outBuf.String(), errBuf.String()))
-			if runErr != nil {
-				combinedOutput.WriteString(fmt.Sprintf("[ERROR] Command failed: %v\n", runErr))
-				return combinedOutput.String(), runErr
-			}
+	// check if the stop button is clicked, the command timed out or the command finished
+	select {
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		<-done // wait for the run goroutine to finish
+		runErr = ctx.Err()
+
+	case <-done:
+		if runErr != nil && conn.Err() != nil {
+			// the keepalive closed a dead connection under the command
+			runErr = conn.Err()
 		}
-
-		session.Close()
 	}
 
-	return combinedOutput.String(), nil
+	outLines.Flush()
+	errLines.Flush()
+	outBuf.Finish()
+	errBuf.Finish()
+
+	return outBuf, errBuf, runErr
 }
 
 // RunJob is the primary job orchestration method, launched by the REST API to execute a full job.
@@ -190,7 +257,7 @@ func sshRunCmd(ctx context.Context, app *App, target SSHJobTarget) (string, erro
 // If the job is canceled (via StopJob), or a command fails, execution stops immediately, cleanup is performed,
 // and an appropriate error and all partial output are returned and surfaced to the frontend.
 // Always resets internal cancel func, clears the session pointer, and updates activity and state on completion or stop.
-func (app *App) RunJob(ctx context.Context) JobResult {
+func (app *App) RunJob(ctx context.Context, req RunRequest) JobResult {
 	app.mutex.Lock()
 
 	if app.running {
@@ -198,6 +265,12 @@ func (app *App) RunJob(ctx context.Context) JobResult {
 		return JobResult{Running: true, Error: "job already running"}
 	}
 
+	req.ID = uuid.New().String()
+	req.RunType = Manual
+
+	// open the event stream now so clients can subscribe as soon as the id is returned
+	app.events.Open(req.ID)
+
 	// background routine to run the job
 	go func() {
 		defer app.ResetApp()
@@ -211,10 +284,10 @@ func (app *App) RunJob(ctx context.Context) JobResult {
 
 		app.mutex.Unlock()
 
-		app.SetLastResult(app.ExecuteRunnerTasks(ctx, Manual))
+		app.SetLastResult(app.ExecuteRunnerTasks(ctx, req))
 	}()
 
-	return JobResult{Running: true}
+	return JobResult{ID: req.ID, Running: true, RunType: Manual, Trigger: req.Trigger, Profile: req.Profile}
 }
 
 // StopJob allows a running job to be forcibly stopped, either via API or UI action.
@@ -230,8 +303,8 @@ func (app *App) StopJob() error {
 		return fmt.Errorf("no job running")
 	}
 
-	if app.persistentHandle != nil {
-		go app.persistentHandle.Close() // Gracefully stops persistent SSH command
+	for _, h := range app.persistentHandles {
+		go h.Close() // Gracefully stops persistent SSH commands
 	}
 
 	if app.activeSession != nil {
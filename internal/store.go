@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrNotFound is returned by a store when the requested record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ResultStore persists every JobResult so reports survive a restart of the runner.
+type ResultStore interface {
+	// SaveResult inserts or replaces the result identified by res.ID.
+	SaveResult(res JobResult) error
+	// GetResult returns the result with the given job ID.
+	GetResult(id string) (JobResult, error)
+	// LatestResult returns the most recently completed result of any run type.
+	LatestResult() (JobResult, error)
+	// LatestScheduleResult returns the most recent result produced by the given schedule.
+	LatestScheduleResult(scheduleID string) (JobResult, error)
+	// ListResults returns one page of results matching filter, newest first,
+	// and the cursor for the next page ("" when there are no more).
+	ListResults(filter JobFilter) ([]JobResult, string, error)
+}
+
+// ScheduleStore persists schedules so they can be re-registered after a restart.
+type ScheduleStore interface {
+	// SaveSchedule inserts or replaces the schedule identified by sched.ID.
+	SaveSchedule(sched Schedule) error
+	DeleteSchedule(id string) error
+	ListSchedules() ([]Schedule, error)
+}
+
+// Store is the persistence backend of the App.
+type Store interface {
+	ResultStore
+	ScheduleStore
+	Close() error
+}
+
+// OpenStore opens the store at path. The special path ":memory:" returns
+// a non-persistent in-memory store.
+func OpenStore(path string) (Store, error) {
+	if path == ":memory:" {
+		return NewMemoryStore(), nil
+	}
+
+	return NewBoltStore(path)
+}
+
+// MemoryStore is an in-memory Store, used for tests or when persistence is disabled.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	results   map[string]JobResult
+	schedules map[string]Schedule
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		results:   map[string]JobResult{},
+		schedules: map[string]Schedule{},
+	}
+}
+
+func (m *MemoryStore) SaveResult(res JobResult) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.results[res.ID] = res
+
+	return nil
+}
+
+func (m *MemoryStore) GetResult(id string) (JobResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	res, ok := m.results[id]
+	if !ok {
+		return JobResult{}, ErrNotFound
+	}
+
+	return res, nil
+}
+
+func (m *MemoryStore) LatestResult() (JobResult, error) {
+	return m.latest(func(JobResult) bool { return true })
+}
+
+func (m *MemoryStore) LatestScheduleResult(scheduleID string) (JobResult, error) {
+	return m.latest(func(res JobResult) bool { return res.ScheduleID == scheduleID })
+}
+
+func (m *MemoryStore) ListResults(filter JobFilter) ([]JobResult, string, error) {
+	after, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mutex.Lock()
+	all := make([]JobResult, 0, len(m.results))
+	for _, res := range m.results {
+		all = append(all, res)
+	}
+	m.mutex.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return resultKey(all[i]) > resultKey(all[j]) })
+
+	var list []JobResult
+	for _, res := range all {
+		key := resultKey(res)
+		if after != "" && key >= after {
+			continue
+		}
+		if !filter.Match(res) {
+			continue
+		}
+		if filter.Limit > 0 && len(list) == filter.Limit {
+			return list, encodeCursor(resultKey(list[len(list)-1])), nil
+		}
+		list = append(list, res)
+	}
+
+	return list, "", nil
+}
+
+func (m *MemoryStore) SaveSchedule(sched Schedule) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.schedules[sched.ID] = sched
+
+	return nil
+}
+
+func (m *MemoryStore) DeleteSchedule(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.schedules, id)
+
+	return nil
+}
+
+func (m *MemoryStore) ListSchedules() ([]Schedule, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := make([]Schedule, 0, len(m.schedules))
+	for _, sched := range m.schedules {
+		list = append(list, sched)
+	}
+
+	return list, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// latest returns the newest result accepted by match.
+func (m *MemoryStore) latest(match func(JobResult) bool) (JobResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := make([]JobResult, 0, len(m.results))
+	for _, res := range m.results {
+		if match(res) {
+			list = append(list, res)
+		}
+	}
+
+	if len(list) == 0 {
+		return JobResult{}, ErrNotFound
+	}
+
+	sort.Slice(list, func(i, j int) bool { return resultKey(list[i]) > resultKey(list[j]) })
+
+	return list[0], nil
+}
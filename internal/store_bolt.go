@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket     = []byte("jobs")          // result key → JobResult JSON, ordered by completion time
+	jobIndexBucket = []byte("job_index")     // job id → result key
+	schedJobBucket = []byte("schedule_jobs") // schedule id → latest job id
+	schedBucket    = []byte("schedules")     // schedule id → Schedule JSON
+)
+
+// BoltStore is a Store backed by an embedded BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the BoltDB file at path and prepares its buckets.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, jobIndexBucket, schedJobBucket, schedBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error preparing store %s: %w", path, err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// resultKey orders results by completion time, with the ID breaking ties.
+func resultKey(res JobResult) string {
+	return fmt.Sprintf("%019d-%s", res.EndTime.UnixNano(), res.ID)
+}
+
+func (b *BoltStore) SaveResult(res JobResult) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobsBucket)
+		index := tx.Bucket(jobIndexBucket)
+		key := []byte(resultKey(res))
+
+		// Drop the previous copy if the end time (and therefore the key) moved
+		if old := index.Get([]byte(res.ID)); old != nil && string(old) != string(key) {
+			if err := jobs.Delete(old); err != nil {
+				return err
+			}
+		}
+
+		if err := jobs.Put(key, data); err != nil {
+			return err
+		}
+		if err := index.Put([]byte(res.ID), key); err != nil {
+			return err
+		}
+
+		if res.ScheduleID != "" {
+			return tx.Bucket(schedJobBucket).Put([]byte(res.ScheduleID), []byte(res.ID))
+		}
+
+		return nil
+	})
+}
+
+func (b *BoltStore) GetResult(id string) (JobResult, error) {
+	var res JobResult
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return getResult(tx, id, &res)
+	})
+
+	return res, err
+}
+
+func (b *BoltStore) LatestResult() (JobResult, error) {
+	var res JobResult
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		_, data := tx.Bucket(jobsBucket).Cursor().Last()
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &res)
+	})
+
+	return res, err
+}
+
+func (b *BoltStore) LatestScheduleResult(scheduleID string) (JobResult, error) {
+	var res JobResult
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(schedJobBucket).Get([]byte(scheduleID))
+		if id == nil {
+			return ErrNotFound
+		}
+		return getResult(tx, string(id), &res)
+	})
+
+	return res, err
+}
+
+func (b *BoltStore) ListResults(filter JobFilter) ([]JobResult, string, error) {
+	after, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var list []JobResult
+	var next string
+
+	err = b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(jobsBucket).Cursor()
+
+		// Position on the newest key strictly older than the cursor
+		var k, v []byte
+		if after == "" {
+			k, v = c.Last()
+		} else {
+			if k, v = c.Seek([]byte(after)); k == nil {
+				k, v = c.Last()
+			}
+			for k != nil && string(k) >= after {
+				k, v = c.Prev()
+			}
+		}
+
+		var lastKey string
+		for ; k != nil; k, v = c.Prev() {
+			var res JobResult
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			if !filter.Match(res) {
+				continue
+			}
+			if filter.Limit > 0 && len(list) == filter.Limit {
+				next = encodeCursor(lastKey)
+				break
+			}
+			list = append(list, res)
+			lastKey = string(k)
+		}
+
+		return nil
+	})
+
+	return list, next, err
+}
+
+func (b *BoltStore) SaveSchedule(sched Schedule) error {
+	data, err := json.Marshal(sched)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedBucket).Put([]byte(sched.ID), data)
+	})
+}
+
+func (b *BoltStore) DeleteSchedule(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedBucket).Delete([]byte(id))
+	})
+}
+
+func (b *BoltStore) ListSchedules() ([]Schedule, error) {
+	var list []Schedule
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedBucket).ForEach(func(_, v []byte) error {
+			var sched Schedule
+			if err := json.Unmarshal(v, &sched); err != nil {
+				return err
+			}
+			list = append(list, sched)
+			return nil
+		})
+	})
+
+	return list, err
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// getResult resolves a job id through the index and decodes the stored result into res.
+func getResult(tx *bolt.Tx, id string, res *JobResult) error {
+	key := tx.Bucket(jobIndexBucket).Get([]byte(id))
+	if key == nil {
+		return ErrNotFound
+	}
+
+	data := tx.Bucket(jobsBucket).Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+
+	return json.Unmarshal(data, res)
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore runs the same checks against every Store implementation.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		res := JobResult{
+			ID:        fmt.Sprintf("job-%d", i),
+			StartTime: base.Add(time.Duration(i) * time.Hour),
+			EndTime:   base.Add(time.Duration(i)*time.Hour + time.Minute),
+			RunType:   Manual,
+		}
+		if i%2 == 1 {
+			res.RunType = Scheduled
+			res.ScheduleID = "sched-1"
+		}
+		if err := store.SaveResult(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.GetResult("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetResult(missing) error = %v, want ErrNotFound", err)
+	}
+	if res, err := store.GetResult("job-2"); err != nil || res.ID != "job-2" {
+		t.Errorf("GetResult(job-2) = %q, %v", res.ID, err)
+	}
+	if res, err := store.LatestResult(); err != nil || res.ID != "job-4" {
+		t.Errorf("LatestResult() = %q, %v, want job-4", res.ID, err)
+	}
+	if res, err := store.LatestScheduleResult("sched-1"); err != nil || res.ID != "job-3" {
+		t.Errorf("LatestScheduleResult() = %q, %v, want job-3", res.ID, err)
+	}
+
+	// two pages of two, then the last one, newest first
+	var ids []string
+	filter := JobFilter{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("ListResults() does not stop paging")
+		}
+		list, next, err := store.ListResults(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range list {
+			ids = append(ids, res.ID)
+		}
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+	if want := "[job-4 job-3 job-2 job-1 job-0]"; fmt.Sprint(ids) != want {
+		t.Errorf("ListResults() pages = %v, want %v", ids, want)
+	}
+
+	scheduled := Scheduled
+	list, _, err := store.ListResults(JobFilter{RunType: &scheduled, Limit: 10})
+	if err != nil || len(list) != 2 {
+		t.Errorf("ListResults(scheduled) = %d results, %v, want 2", len(list), err)
+	}
+
+	if _, _, err := store.ListResults(JobFilter{Cursor: "%%%"}); err == nil {
+		t.Error("ListResults() accepted an invalid cursor")
+	}
+
+	if err := store.SaveSchedule(Schedule{ID: "sched-1", Time: base}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveSchedule(Schedule{ID: "sched-1", Time: base.Add(time.Hour), HasError: true}); err != nil {
+		t.Fatal(err)
+	}
+	scheds, err := store.ListSchedules()
+	if err != nil || len(scheds) != 1 || !scheds[0].HasError {
+		t.Errorf("ListSchedules() = %+v, %v, want the replaced schedule", scheds, err)
+	}
+	if err := store.DeleteSchedule("sched-1"); err != nil {
+		t.Fatal(err)
+	}
+	if scheds, _ := store.ListSchedules(); len(scheds) != 0 {
+		t.Errorf("ListSchedules() after delete = %d schedules", len(scheds))
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "routetest.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	testStore(t, store)
+}
@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route event types
+const (
+	EventRouteTake   = "route-take"   // a take was requested on the router
+	EventRouteChange = "route-change" // the router connected a destination to a source
+	EventRouteClear  = "route-clear"  // the router disconnected a destination
+	EventSalvo       = "salvo"
+	EventClientJoin  = "client-join"  // an endpoint joined a multicast group
+	EventClientLeave = "client-leave" // an endpoint left a multicast group
+	EventClient      = "client-update"
+	EventLwrpUpdated = "lwrp-updated" // the slab applied a new source to an output
+)
+
+// RouteEvent is one step of a route change, parsed from a line of stage output.
+type RouteEvent struct {
+	Time        time.Time
+	Type        string
+	Device      string `json:",omitempty"`
+	Source      string `json:",omitempty"`
+	Destination string `json:",omitempty"`
+	Multicast   string `json:",omitempty"`
+	Stage       string // stage whose output had the line
+	Parser      string
+	Line        string
+}
+
+// LogLine is one line of stage output handed to a parser.
+type LogLine struct {
+	Text   string
+	Time   time.Time // when the source provides it, e.g. the @timestamp of an Elasticsearch hit
+	Device string    // when the source provides it
+	Ref    time.Time // completes timestamps without a date, e.g. syslog's "Jan  2 15:04:05"
+}
+
+// LogParser turns lines of stage output into route events. Stages list their parsers by name
+// under "parse".
+type LogParser interface {
+	// Parse returns the event of one line, or false when the line is not one the parser knows.
+	Parse(line LogLine) (RouteEvent, bool)
+}
+
+var (
+	logParsersMutex sync.RWMutex
+	logParsers      = map[string]LogParser{}
+)
+
+// RegisterLogParser makes a parser available to the stages' parse lists. A later registration
+// of the same name replaces the earlier one.
+func RegisterLogParser(name string, parser LogParser) {
+	logParsersMutex.Lock()
+	defer logParsersMutex.Unlock()
+
+	logParsers[strings.ToLower(name)] = parser
+}
+
+// lookupLogParser returns the parser registered as name.
+func lookupLogParser(name string) (LogParser, bool) {
+	logParsersMutex.RLock()
+	defer logParsersMutex.RUnlock()
+
+	parser, ok := logParsers[strings.ToLower(name)]
+	return parser, ok
+}
+
+// LogParserNames returns the names of the registered parsers, sorted.
+func LogParserNames() []string {
+	logParsersMutex.RLock()
+	defer logParsersMutex.RUnlock()
+
+	names := make([]string, 0, len(logParsers))
+	for name := range logParsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	RegisterLogParser("magrtrsrv", routeLogParser{program: "magrtrsrv", fallback: EventRouteChange, keywords: []eventKeyword{
+		{regexp.MustCompile(`(?i)\bsalvo`), EventSalvo},
+		{regexp.MustCompile(`(?i)\btake`), EventRouteTake},
+		{regexp.MustCompile(`(?i)\b(disconnect|clear|unroute)`), EventRouteClear},
+	}})
+	RegisterLogParser("magclientsrv", routeLogParser{program: "magclientsrv", fallback: EventClient, keywords: []eventKeyword{
+		{regexp.MustCompile(`(?i)\b(leave|unsubscri)`), EventClientLeave},
+		{regexp.MustCompile(`(?i)\b(join|subscri)`), EventClientJoin},
+	}})
+	RegisterLogParser("lwrp", lwrpParser{})
+}
+
+// Parts of route log lines
+var (
+	logTimestampRx = regexp.MustCompile(`^\[?(\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)\]?`)
+	syslogTimeRx   = regexp.MustCompile(`^([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2})`)
+	clockTimeRx    = regexp.MustCompile(`^\[?(\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)\]?`)
+
+	sourceRx    = regexp.MustCompile(`(?i)\b(?:src|source)\b[\s:=#]*"?([\w./-]+)`)
+	destRx      = regexp.MustCompile(`(?i)\b(?:dst|dest|destination)\b[\s:=#]*"?([\w./-]+)`)
+	deviceRx    = regexp.MustCompile(`(?i)\b(?:device|router|device_name)\s*[:=]\s*"?([\w.-]+)`)
+	multicastRx = regexp.MustCompile(`\b(2(?:2[4-9]|3\d)(?:\.(?:25[0-5]|2[0-4]\d|1?\d?\d)){3})\b`)
+	programRx   = regexp.MustCompile(`\b(magrtrsrv|magclientsrv|LwrpUpdated)\b`) // names that tie a line to its parser
+	lwrpNameRx  = regexp.MustCompile(`\bNAME:"([^"]*)"`)
+)
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006/01/02 15:04:05.999999999",
+}
+
+// parseLogTime reads the timestamp at the start of text. Timestamps without a zone are in ref's
+// location. A missing date is the day before, of or after ref's that puts the time nearest to ref;
+// a missing year is ref's, or the one before or after when that is nearer.
+func parseLogTime(text string, ref time.Time) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	loc := ref.Location()
+
+	if m := logTimestampRx.FindStringSubmatch(text); m != nil {
+		value := strings.Replace(m[1], ",", ".", 1)
+		for _, layout := range timestampLayouts {
+			if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	if m := syslogTimeRx.FindStringSubmatch(text); m != nil {
+		if t, err := time.ParseInLocation(time.Stamp, m[1], loc); err == nil {
+			t = t.AddDate(ref.Year(), 0, 0)
+			// a December line read in January belongs to the year before, a January line read
+			// in December to the year after
+			if t.After(ref.AddDate(0, 1, 0)) {
+				t = t.AddDate(-1, 0, 0)
+			} else if t.Before(ref.AddDate(0, -11, 0)) {
+				t = t.AddDate(1, 0, 0)
+			}
+			return t, true
+		}
+	}
+
+	if m := clockTimeRx.FindStringSubmatch(text); m != nil {
+		if t, err := time.ParseInLocation("15:04:05.999999999", strings.Replace(m[1], ",", ".", 1), loc); err == nil {
+			// a line logged just before midnight and read just after is the day before's, one
+			// logged just after midnight by a stage started before it the day after's
+			y, mo, d := ref.Date()
+			nearest := time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+			for _, days := range []int{-1, 1} {
+				if c := nearest.AddDate(0, 0, days); c.Sub(ref).Abs() < nearest.Sub(ref).Abs() {
+					nearest = c
+				}
+			}
+			return nearest, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// submatch returns the first group of rx in text, or "".
+func submatch(rx *regexp.Regexp, text string) string {
+	if m := rx.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// eventKeyword gives lines matching rx an event type.
+type eventKeyword struct {
+	rx   *regexp.Regexp
+	kind string
+}
+
+// routeLogParser reads the timestamped lines of a Magnum service log (magrtrsrv, magclientsrv)
+// that name a source, destination or multicast group. Lines tagged with another service's name,
+// or the slab's LwrpUpdated, are left to that parser.
+type routeLogParser struct {
+	program  string
+	keywords []eventKeyword
+	fallback string // type of lines no keyword matches
+}
+
+func (p routeLogParser) Parse(line LogLine) (RouteEvent, bool) {
+	if program := submatch(programRx, line.Text); program != "" && program != p.program {
+		return RouteEvent{}, false
+	}
+
+	event := RouteEvent{
+		Time:        line.Time,
+		Device:      submatch(deviceRx, line.Text),
+		Source:      submatch(sourceRx, line.Text),
+		Destination: submatch(destRx, line.Text),
+		Multicast:   submatch(multicastRx, line.Text),
+	}
+	if event.Source == "" && event.Destination == "" && event.Multicast == "" {
+		return RouteEvent{}, false
+	}
+
+	if t, ok := parseLogTime(line.Text, line.Ref); ok {
+		event.Time = t
+	}
+	if event.Time.IsZero() {
+		return RouteEvent{}, false
+	}
+	if event.Device == "" {
+		event.Device = line.Device
+	}
+
+	event.Type = p.fallback
+	for _, kw := range p.keywords {
+		if kw.rx.MatchString(line.Text) {
+			event.Type = kw.kind
+			break
+		}
+	}
+
+	return event, true
+}
+
+// lwrpParser reads the slab's LwrpUpdated syslog messages, e.g.
+// `LwrpUpdated DST 6 ADDR:"239.10.64.203" NAME:"IAD1 PGM"`. The source is the stream's name.
+type lwrpParser struct{}
+
+func (lwrpParser) Parse(line LogLine) (RouteEvent, bool) {
+	if !strings.Contains(line.Text, "LwrpUpdated") {
+		return RouteEvent{}, false
+	}
+
+	event := RouteEvent{
+		Time:        line.Time,
+		Type:        EventLwrpUpdated,
+		Device:      line.Device,
+		Source:      submatch(lwrpNameRx, line.Text),
+		Destination: submatch(destRx, line.Text),
+		Multicast:   submatch(multicastRx, line.Text),
+	}
+
+	if event.Time.IsZero() {
+		t, ok := parseLogTime(line.Text, line.Ref)
+		if !ok {
+			return RouteEvent{}, false
+		}
+		event.Time = t
+	}
+
+	return event, true
+}
+
+// validateParsers checks that every parser a stage names is registered.
+func validateParsers(names []string) error {
+	for _, name := range names {
+		if _, ok := lookupLogParser(name); !ok {
+			return fmt.Errorf("parse: unknown parser %q (known: %s)", name, strings.Join(LogParserNames(), ", "))
+		}
+	}
+
+	return nil
+}
+
+// stageLogLines returns the lines of a stage's output for its parsers. Timestamps without a zone
+// are read in loc.
+func stageLogLines(stage StageResult, loc *time.Location) []LogLine {
+	if len(stage.Logs) > 0 && len(stage.Results) == 0 {
+		lines := make([]LogLine, len(stage.Logs))
+		for i, entry := range stage.Logs {
+			lines[i] = LogLine{Text: entry.Message, Time: entry.Timestamp, Device: entry.Device, Ref: entry.Timestamp}
+		}
+		return lines
+	}
+
+	var lines []LogLine
+	texts, _ := stageLines(stage)
+	for _, text := range texts {
+		if text = strings.TrimSpace(text); text != "" {
+			lines = append(lines, LogLine{Text: text, Ref: stage.StartTime.In(loc)})
+		}
+	}
+
+	return lines
+}
+
+// parseEvents adds the route events in the output of stage i, found by the named parsers, to the
+// job's timeline. Each line goes to the first parser that accepts it.
+func (run *pipelineRun) parseEvents(i int, parsers []string) {
+	stage := run.result.Stages[i]
+	if len(parsers) == 0 || stage.Status == StageSkipped {
+		return
+	}
+
+	loc := time.Local
+	if host, ok := run.app.Config.File.Hosts[stage.Host]; ok && host.logLocation != nil {
+		loc = host.logLocation
+	}
+
+	for _, line := range stageLogLines(stage, loc) {
+		for _, name := range parsers {
+			parser, ok := lookupLogParser(name)
+			if !ok {
+				continue
+			}
+
+			if event, ok := parser.Parse(line); ok {
+				event.Stage = stage.Name
+				event.Parser = name
+				event.Line = line.Text
+				run.result.Timeline = append(run.result.Timeline, event)
+				break
+			}
+		}
+	}
+
+	sortTimeline(run.result.Timeline)
+}
+
+// sortTimeline orders events by time, keeping the output order of events at the same time.
+func sortTimeline(events []RouteEvent) {
+	slices.SortStableFunc(events, func(a, b RouteEvent) int {
+		return a.Time.Compare(b.Time)
+	})
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+// est stands in for a host's log_timezone, so the tests do not depend on tzdata.
+var est = time.FixedZone("EST", -5*3600)
+
+func TestParseLogTime(t *testing.T) {
+	// the stage started ten seconds before midnight
+	ref := time.Date(2026, 3, 4, 23, 59, 50, 0, est)
+
+	tests := []struct {
+		name string
+		text string
+		ref  time.Time
+		want time.Time
+	}{
+		{"RFC 3339", "2026-03-05T04:59:58.412Z magrtrsrv: Take src 1042 dst 6", ref, time.Date(2026, 3, 5, 4, 59, 58, 412e6, time.UTC)},
+		{"with offset", "[2026-03-04T23:59:58.530-05:00] magclientsrv: client 10.9.4.21 join group 239.10.64.203", ref, time.Date(2026, 3, 4, 23, 59, 58, 530e6, est)},
+		{"zone-less in the host zone", "2026-03-04 23:59:58.412 magrtrsrv INFO Route change: router=iad1-rtr01 src 1042 dst 6", ref, time.Date(2026, 3, 4, 23, 59, 58, 412e6, est)},
+		{"comma fraction", "[2026-03-04 23:59:58,412] magrtrsrv: Take src 1042 dst 6", ref, time.Date(2026, 3, 4, 23, 59, 58, 412e6, est)},
+		{"slashes", "2026/03/04 23:59:58 magrtrsrv: Take src 1042 dst 6", ref, time.Date(2026, 3, 4, 23, 59, 58, 0, est)},
+		{"syslog", "Mar  4 23:59:59 magnum01 magrtrsrv[2211]: Take router=iad1-rtr01 src 1042 dst 6", ref, time.Date(2026, 3, 4, 23, 59, 59, 0, est)},
+		{
+			name: "syslog December read in January",
+			text: "Dec 31 23:59:58 iad1bc-slab017 lwrp: LwrpUpdated DST 6 ADDR:\"239.10.64.203\" NAME:\"IAD1 PGM\"",
+			ref:  time.Date(2027, 1, 1, 0, 0, 10, 0, est),
+			want: time.Date(2026, 12, 31, 23, 59, 58, 0, est),
+		},
+		{
+			name: "syslog January read in December",
+			text: "Jan  1 00:00:02 iad1bc-slab017 lwrp: LwrpUpdated DST 6 ADDR:\"239.10.64.203\" NAME:\"IAD1 PGM\"",
+			ref:  time.Date(2026, 12, 31, 23, 59, 50, 0, est),
+			want: time.Date(2027, 1, 1, 0, 0, 2, 0, est),
+		},
+		{"clock after the start", "23:59:58.120 magclientsrv subscribe 239.10.64.203 dst 6", ref, time.Date(2026, 3, 4, 23, 59, 58, 120e6, est)},
+		{"clock just before the start", "[23:59:40] magclientsrv subscribe 239.10.64.203 dst 6", ref, time.Date(2026, 3, 4, 23, 59, 40, 0, est)},
+		{"clock after midnight", "00:00:03,250 magclientsrv subscribe 239.10.64.203 dst 6", ref, time.Date(2026, 3, 5, 0, 0, 3, 250e6, est)},
+		{
+			// read by a stage that started just after midnight
+			name: "clock before midnight",
+			text: "23:59:50 magrtrsrv: Take src 1042 dst 6",
+			ref:  time.Date(2026, 3, 5, 0, 0, 30, 0, est),
+			want: time.Date(2026, 3, 4, 23, 59, 50, 0, est),
+		},
+		{"clock hours after the start", "11:40:00 magrtrsrv: Take src 1042 dst 6", time.Date(2026, 3, 4, 0, 0, 30, 0, est), time.Date(2026, 3, 4, 11, 40, 0, 0, est)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLogTime(tt.text, tt.ref)
+			if !ok || !got.Equal(tt.want) {
+				t.Errorf("parseLogTime() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+
+	if got, ok := parseLogTime("Route change: src 1042 dst 6", ref); ok {
+		t.Errorf("parseLogTime() without a timestamp = %v", got)
+	}
+}
+
+func TestRouteLogParser(t *testing.T) {
+	router, _ := lookupLogParser("magrtrsrv")
+	client, _ := lookupLogParser("magclientsrv")
+	ref := time.Date(2026, 3, 4, 23, 59, 50, 0, est)
+
+	tests := []struct {
+		name   string
+		parser LogParser
+		text   string
+		want   RouteEvent
+		ok     bool
+	}{
+		{
+			name:   "syslog take",
+			parser: router,
+			text:   "Mar  4 23:59:59 magnum01 magrtrsrv[2211]: Take router=iad1-rtr01 src 1042 dst 6",
+			want:   RouteEvent{Time: time.Date(2026, 3, 4, 23, 59, 59, 0, est), Type: EventRouteTake, Device: "iad1-rtr01", Source: "1042", Destination: "6"},
+			ok:     true,
+		},
+		{
+			name:   "route change",
+			parser: router,
+			text:   "2026-03-04 23:59:58.412 magrtrsrv INFO Route change: device=iad1-rtr01 source=1042 destination=6 mcast 239.10.64.203",
+			want:   RouteEvent{Time: time.Date(2026, 3, 4, 23, 59, 58, 412e6, est), Type: EventRouteChange, Device: "iad1-rtr01", Source: "1042", Destination: "6", Multicast: "239.10.64.203"},
+			ok:     true,
+		},
+		{
+			name:   "disconnect",
+			parser: router,
+			text:   "2026-03-04 23:59:57 magrtrsrv INFO Disconnect dst 6",
+			want:   RouteEvent{Time: time.Date(2026, 3, 4, 23, 59, 57, 0, est), Type: EventRouteClear, Destination: "6"},
+			ok:     true,
+		},
+		{
+			name:   "client join after midnight",
+			parser: client,
+			text:   "00:00:03,250 magclientsrv: client 10.9.4.21 join group 239.10.64.203",
+			want:   RouteEvent{Time: time.Date(2026, 3, 5, 0, 0, 3, 250e6, est), Type: EventClientJoin, Multicast: "239.10.64.203"},
+			ok:     true,
+		},
+		{
+			name:   "client leave",
+			parser: client,
+			text:   "[2026-03-04T23:59:58.530-05:00] magclientsrv: client 10.9.4.21 leave group 239.10.64.200",
+			want:   RouteEvent{Time: time.Date(2026, 3, 4, 23, 59, 58, 530e6, est), Type: EventClientLeave, Multicast: "239.10.64.200"},
+			ok:     true,
+		},
+		{name: "other service's line", parser: router, text: "23:59:58 magclientsrv: client 10.9.4.21 join group 239.10.64.203"},
+		{name: "slab line", parser: router, text: "Mar  4 23:59:59 iad1bc-slab017 lwrp: LwrpUpdated DST 6 ADDR:\"239.10.64.203\""},
+		{name: "no route", parser: router, text: "2026-03-04 23:59:58 magrtrsrv INFO heartbeat ok"},
+		{name: "no time", parser: router, text: "magrtrsrv: Take src 1042 dst 6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.parser.Parse(LogLine{Text: tt.text, Ref: ref})
+			if ok != tt.ok {
+				t.Fatalf("Parse() ok = %v, want %v (%+v)", ok, tt.ok, got)
+			}
+			if ok && (!got.Time.Equal(tt.want.Time) || got.Type != tt.want.Type || got.Device != tt.want.Device ||
+				got.Source != tt.want.Source || got.Destination != tt.want.Destination || got.Multicast != tt.want.Multicast) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLwrpParser(t *testing.T) {
+	at := time.Date(2026, 3, 5, 4, 59, 59, 873e6, time.UTC)
+	ref := time.Date(2026, 12, 31, 23, 59, 50, 0, est)
+
+	tests := []struct {
+		name string
+		line LogLine
+		want RouteEvent
+		ok   bool
+	}{
+		{
+			// an Elasticsearch hit carries the time and device
+			name: "slab-logs entry",
+			line: LogLine{Text: `LwrpUpdated DST 6 ADDR:"239.10.64.203" NAME:"IAD1 PGM"`, Time: at, Device: "iad1bc-slab017", Ref: at},
+			want: RouteEvent{Time: at, Device: "iad1bc-slab017", Source: "IAD1 PGM", Destination: "6", Multicast: "239.10.64.203"},
+			ok:   true,
+		},
+		{
+			name: "syslog line across New Year",
+			line: LogLine{Text: `Jan  1 00:00:02 iad1bc-slab017 lwrp: LwrpUpdated DST 12 ADDR:"239.10.64.210" NAME:"IAD1 BKP"`, Ref: ref},
+			want: RouteEvent{Time: time.Date(2027, 1, 1, 0, 0, 2, 0, est), Source: "IAD1 BKP", Destination: "12", Multicast: "239.10.64.210"},
+			ok:   true,
+		},
+		{name: "other message", line: LogLine{Text: "Mar  4 23:59:59 iad1bc-slab017 lwrp: LinkUp port 3", Ref: ref}},
+		{name: "no time", line: LogLine{Text: `LwrpUpdated DST 6 ADDR:"239.10.64.203"`, Ref: ref}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := lwrpParser{}.Parse(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("Parse() ok = %v, want %v (%+v)", ok, tt.ok, got)
+			}
+			if ok && (!got.Time.Equal(tt.want.Time) || got.Type != EventLwrpUpdated || got.Device != tt.want.Device ||
+				got.Source != tt.want.Source || got.Destination != tt.want.Destination || got.Multicast != tt.want.Multicast) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageLogLinesTimezone(t *testing.T) {
+	start := time.Date(2026, 3, 5, 4, 59, 50, 0, time.UTC) // 23:59:50 in the host's zone
+	stage := StageResult{StartTime: start, Results: []CommandResult{{Stdout: "23:59:58 magrtrsrv: Take src 1042 dst 6\n"}}}
+
+	lines := stageLogLines(stage, est)
+	if len(lines) != 1 {
+		t.Fatalf("stageLogLines() = %+v", lines)
+	}
+
+	got, ok := parseLogTime(lines[0].Text, lines[0].Ref)
+	if want := start.Add(8 * time.Second); !ok || !got.Equal(want) {
+		t.Errorf("time = %v, want %v", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/json"
+	"fmt"
 )
 
 // RunType is a small enum
@@ -25,24 +26,21 @@ func (rt RunType) MarshalJSON() ([]byte, error) {
 	return json.Marshal(runTypeName[rt])
 }
 
-type Step int
+func (rt *RunType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
 
-const (
-	One Step = iota
-	Two
-	Three
-	Four
-	Five
-	Six
-)
+	for k, v := range runTypeName {
+		if v == name {
+			*rt = k
+			return nil
+		}
+	}
 
-type Steps struct {
-	one      Step
-	two      Step
-	three    Step
-	four     Step
-	five     Step
-	complete Step
+	return fmt.Errorf("unknown run type %q", name)
 }
 
-var step Steps = Steps{One, Two, Three, Four, Five, Six}
+// Step is the index of the pipeline stage currently running; the number of stages means complete.
+type Step int
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// embeddedScripts are the files of internal/scripts, shipped inside the binary.
+//
+//go:embed scripts
+var embeddedScripts embed.FS
+
+// embedPrefix marks an upload source as a file of embeddedScripts.
+const embedPrefix = "embed:"
+
+// workDirTemp selects a per-run temporary working directory, removed when the run ends.
+const workDirTemp = "temp"
+
+const defaultUploadMode = 0o644
+
+// UploadConfig is one file copied to a host before its commands run.
+type UploadConfig struct {
+	Source string `mapstructure:"source"` // local path, or "embed:<name>" for a file embedded in the binary
+	Dest   string `mapstructure:"dest"`   // remote path, relative to the working directory; defaults to the source's file name
+	Mode   string `mapstructure:"mode"`   // octal permissions, defaults to "0644"
+
+	perm os.FileMode
+}
+
+// resolveUploads checks that every upload source exists and fills in the destination and mode.
+func resolveUploads(host *HostEntry) error {
+	for i := range host.Uploads {
+		upload := &host.Uploads[i]
+
+		if _, err := readUploadSource(upload.Source); err != nil {
+			return fmt.Errorf("upload %q: %w", upload.Source, err)
+		}
+
+		if upload.Dest == "" {
+			upload.Dest = path.Base(filepath.ToSlash(strings.TrimPrefix(upload.Source, embedPrefix)))
+		}
+
+		upload.perm = defaultUploadMode
+		if upload.Mode != "" {
+			mode, err := strconv.ParseUint(upload.Mode, 8, 32)
+			if err != nil || mode > 0o777 {
+				return fmt.Errorf("upload %q: invalid mode %q", upload.Source, upload.Mode)
+			}
+			upload.perm = os.FileMode(mode)
+		}
+	}
+
+	return nil
+}
+
+// readUploadSource returns the content of a local or embedded upload source.
+func readUploadSource(source string) ([]byte, error) {
+	if name, ok := strings.CutPrefix(source, embedPrefix); ok {
+		return embeddedScripts.ReadFile(path.Join("scripts", name))
+	}
+
+	return os.ReadFile(source)
+}
+
+// workDirPath resolves a host's workdir setting into the remote directory ("" for the login
+// directory).
+func (app *App) workDirPath(workDir string) string {
+	if workDir != workDirTemp {
+		return workDir
+	}
+
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	return "/tmp/routetest-" + app.jobID
+}
+
+// prepareWorkDir creates the working directory of target and uploads its files over SFTP on conn,
+// verifying each by its SHA-256 checksum. Files whose remote copy already matches are left alone.
+// It returns the working directory ("" for the login directory); a temporary one is kept until
+// the run ends (see removeWorkDir).
+func (app *App) prepareWorkDir(ctx context.Context, conn *sshClient, target SSHJobTarget, output *strings.Builder) (string, error) {
+	workDir := app.workDirPath(target.WorkDir)
+	if workDir == "" && len(target.Uploads) == 0 {
+		return "", nil
+	}
+
+	app.SetJobActivity(fmt.Sprintf("Uploading files to %s (%s)", target.Label, target.IP))
+
+	client, err := sftp.NewClient(conn.Client)
+	if err != nil {
+		return "", fmt.Errorf("SFTP on %s: %w", target.Label, err)
+	}
+	defer client.Close()
+
+	if workDir != "" {
+		if err := client.MkdirAll(workDir); err != nil {
+			return "", fmt.Errorf("create working directory %s on %s: %w", workDir, target.Label, err)
+		}
+	}
+
+	for _, upload := range target.Uploads {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		dest := upload.Dest
+		if workDir != "" && !path.IsAbs(dest) {
+			dest = path.Join(workDir, dest)
+		}
+
+		status, err := uploadFile(client, upload, dest)
+		if err != nil {
+			return "", fmt.Errorf("upload %s to %s:%s: %w", upload.Source, target.Label, dest, err)
+		}
+
+		output.WriteString(fmt.Sprintf("[UPLOAD] %s -> %s:%s (%s)\n", upload.Source, target.Label, dest, status))
+	}
+
+	return workDir, nil
+}
+
+// removeWorkDir removes the temporary working directory of target over a new connection, so it
+// still works after the run was stopped. Failures are only logged.
+func (app *App) removeWorkDir(target SSHJobTarget) {
+	workDir := app.workDirPath(workDirTemp)
+
+	conn, err := dialSSH(context.Background(), target)
+	if err == nil {
+		var client *sftp.Client
+		if client, err = sftp.NewClient(conn.Client); err == nil {
+			err = client.RemoveAll(workDir)
+			client.Close()
+		}
+		conn.Close()
+	}
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to remove working directory", "host", target.Label, "dir", workDir, "error", err)
+	}
+}
+
+// uploadFile copies the upload's source to dest unless dest already has the same content, checks
+// the remote checksum and sets the mode. It returns a short status for the stage output.
+func uploadFile(client *sftp.Client, upload UploadConfig, dest string) (string, error) {
+	data, err := readUploadSource(upload.Source)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+	status := fmt.Sprintf("%d bytes, sha256 %s", len(data), want[:12])
+
+	if have, err := remoteChecksum(client, dest); err == nil && have == want {
+		return status + ", unchanged", client.Chmod(dest, upload.perm)
+	}
+
+	if err := client.MkdirAll(path.Dir(dest)); err != nil {
+		return "", err
+	}
+
+	f, err := client.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, bytes.NewReader(data))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if have, err := remoteChecksum(client, dest); err != nil {
+		return "", fmt.Errorf("verify checksum: %w", err)
+	} else if have != want {
+		return "", fmt.Errorf("checksum mismatch: sent sha256 %s, remote has %s", want, have)
+	}
+
+	return status, client.Chmod(dest, upload.perm)
+}
+
+// remoteChecksum returns the hex SHA-256 of a remote file.
+func remoteChecksum(client *sftp.Client, file string) (string, error) {
+	f, err := client.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sshCommandLine is cmd as it runs on target: in the working directory, with the test window
+// exported.
+func (app *App) sshCommandLine(target SSHJobTarget, cmd string) string {
+	return withEnv(target.Window.env(), inDir(app.workDirPath(target.WorkDir), cmd))
+}
+
+// withEnv prefixes cmd so it runs with the given KEY=value environment variables exported.
+func withEnv(env []string, cmd string) string {
+	if len(env) == 0 {
+		return cmd
+	}
+
+	exports := make([]string, len(env))
+	for i, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		exports[i] = fmt.Sprintf("%s='%s'", key, strings.ReplaceAll(value, "'", `'\''`))
+	}
+
+	return fmt.Sprintf("export %s; %s", strings.Join(exports, " "), cmd)
+}
+
+// inDir prefixes cmd so it runs in dir, when one is set.
+func inDir(dir, cmd string) string {
+	if dir == "" {
+		return cmd
+	}
+
+	return fmt.Sprintf("cd '%s' && %s", strings.ReplaceAll(dir, "'", `'\''`), cmd)
+}
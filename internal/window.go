@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TestWindow is the time span of a run's test: from the start of its first test stage to the end of
+// its last, widened by the configured padding. Log-gathering stages keep what falls inside it.
+type TestWindow struct {
+	Start time.Time // first test stage started
+	End   time.Time // last test stage ended
+	From  time.Time // Start minus padding_before
+	To    time.Time // End plus padding_after
+}
+
+// Contains reports whether t falls within the padded window.
+func (w TestWindow) Contains(t time.Time) bool {
+	return !t.Before(w.From) && !t.After(w.To)
+}
+
+func (w TestWindow) String() string {
+	return fmt.Sprintf("%s - %s", w.From.Format(time.RFC3339), w.To.Format(time.RFC3339))
+}
+
+// env returns the window as environment variables for stage commands.
+func (w TestWindow) env() []string {
+	return []string{
+		"ROUTETEST_WINDOW_START=" + w.From.UTC().Format(time.RFC3339Nano),
+		"ROUTETEST_WINDOW_END=" + w.To.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// waitForEnd waits until the end of the padded window has passed, so logs written just after the
+// test are in. It returns ctx's error when the job is stopped first.
+func (w TestWindow) waitForEnd(ctx context.Context, app *App) error {
+	wait := time.Until(w.To)
+	if wait <= 0 {
+		return nil
+	}
+
+	app.SetJobActivity(fmt.Sprintf("Waiting %s for logs at the end of the test window", wait.Round(time.Second)))
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// window returns the test window as of now: it spans the test stages that ran so far. Before any
+// test stage ran, or when the pipeline marks none, it spans the run from its start until now.
+func (run *pipelineRun) window(now time.Time) TestWindow {
+	var w TestWindow
+
+	for _, stage := range run.result.Stages {
+		if !stage.Test || stage.StartTime.IsZero() {
+			continue
+		}
+
+		if w.Start.IsZero() {
+			w.Start = stage.StartTime
+		}
+		w.End = stage.EndTime
+		if w.End.IsZero() {
+			w.End = now // still running
+		}
+	}
+
+	if w.Start.IsZero() {
+		w.Start, w.End = run.result.StartTime, now
+	}
+
+	padding := run.app.Config.File.TestWindow
+	w.From = w.Start.Add(-padding.PaddingBefore)
+	w.To = w.End.Add(padding.PaddingAfter)
+
+	return w
+}